@@ -0,0 +1,197 @@
+package pazuzu
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// apiBuilder builds images with the Docker Engine API.
+type apiBuilder struct {
+	client *docker.Client
+	handle func(BuildEvent)
+}
+
+func (b *apiBuilder) Build(request BuildRequest) error {
+	reader, writer := io.Pipe()
+	opts := docker.BuildImageOptions{
+		Name:          request.Name,
+		NoCache:       request.Options.NoCache,
+		Pull:          request.Options.Pull,
+		BuildArgs:     request.Options.buildArgs(),
+		Dockerfile:    request.DockerfileName,
+		ContextDir:    request.ContextDir,
+		OutputStream:  writer,
+		RawJSONStream: true,
+	}
+	if request.ContextDir == "" {
+		var err error
+		if opts.InputStream, err = dockerfileContext(request.Dockerfile); err != nil {
+			return err
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err := b.client.BuildImage(opts)
+		writer.Close()
+		done <- err
+	}()
+	streamErr := readBuildStream(reader, buildInstructions(request.Dockerfile), b.handle)
+	if err := <-done; err != nil {
+		return fmt.Errorf("could not build image %s: %s", request.Name, err)
+	}
+	return streamErr
+}
+
+func (b *apiBuilder) Tag(image string, name string) error {
+	repository, tag := splitTag(name)
+	return b.client.TagImage(image, docker.TagImageOptions{Repo: repository, Tag: tag, Force: true})
+}
+
+func (b *apiBuilder) Run(image string, command string) error {
+	container, err := b.start(image)
+	if err != nil {
+		return err
+	}
+
+	if err := b.exec(container.ID, command); err != nil {
+		b.stop(container.ID)
+		return err
+	}
+	return b.stop(container.ID)
+}
+
+// Push pushes with the credentials of the Docker client for the registry.
+func (b *apiBuilder) Push(name string) (string, error) {
+	auth, err := RegistryAuth(name)
+	if err != nil {
+		return "", err
+	}
+
+	repository, tag := splitTag(name)
+	reader, writer := io.Pipe()
+	opts := docker.PushImageOptions{
+		Name:          repository,
+		Tag:           tag,
+		OutputStream:  writer,
+		RawJSONStream: true,
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err := b.client.PushImage(opts, auth)
+		writer.Close()
+		done <- err
+	}()
+	digest, streamErr := readPushStream(name, reader, b.handle)
+	if err := <-done; err != nil {
+		return "", &PushError{Image: name, Message: err.Error()}
+	}
+	return digest, streamErr
+}
+
+func (b *apiBuilder) Labels(image string) (map[string]string, error) {
+	result, err := b.client.InspectImage(image)
+	if err != nil {
+		return nil, err
+	}
+	if result.Config == nil {
+		return nil, nil
+	}
+	return result.Config.Labels, nil
+}
+
+func (b *apiBuilder) exec(ID string, cmd string) error {
+	execOpts := docker.CreateExecOptions{
+		Container:    ID,
+		AttachStdin:  false,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd: []string{
+			"/bin/bash",
+			"-c",
+			cmd,
+		},
+		Tty: true,
+	}
+	exec, err := b.client.CreateExec(execOpts)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	var errBuf bytes.Buffer
+
+	startExecOpts := docker.StartExecOptions{
+		Detach:       false,
+		OutputStream: os.Stdout,
+		ErrorStream:  &errBuf,
+		RawTerminal:  true,
+		Tty:          true,
+	}
+
+	err = b.client.StartExec(exec.ID, startExecOpts)
+	if err != nil {
+		return err
+	}
+
+	inspect, err := b.client.InspectExec(exec.ID)
+	if err != nil {
+		return err
+	}
+
+	if inspect.ExitCode > 0 {
+		return fmt.Errorf("exit code %d: %s", inspect.ExitCode, buf.String())
+	}
+
+	return nil
+}
+
+func (b *apiBuilder) start(image string) (*docker.Container, error) {
+	opts := docker.CreateContainerOptions{
+		// the tests need a shell as root, whatever the image is configured with
+		Config: &docker.Config{
+			Image:      image,
+			Tty:        true,
+			Entrypoint: []string{},
+			User:       "root",
+			Cmd: []string{
+				"/bin/sh",
+			},
+		},
+		HostConfig: &docker.HostConfig{
+			Binds: []string{
+				tempDir + ":" + mountPoint,
+			},
+		},
+	}
+
+	container, err := b.client.CreateContainer(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.client.StartContainer(container.ID, nil); err != nil {
+		return nil, err
+	}
+
+	return container, nil
+}
+
+func (b *apiBuilder) stop(ID string) error {
+	if err := b.client.StopContainer(ID, 1); err != nil {
+		return err
+	}
+
+	if err := b.client.RemoveContainer(docker.RemoveContainerOptions{
+		ID: ID,
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
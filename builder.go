@@ -0,0 +1,52 @@
+package pazuzu
+
+import "fmt"
+
+// Builders of images, selected by docker.builder of the configuration.
+const (
+	BuilderAPI     = "api"    // Docker Engine API of the daemon at docker.endpoint
+	BuilderDocker  = "docker" // docker CLI with BuildKit
+	BuilderPodman  = "podman"
+	BuilderBuildah = "buildah"
+)
+
+// Builder builds, tests and pushes images with a container engine.
+type Builder interface {
+	// Build builds the image of the request. A failing build returns a BuildError.
+	Build(request BuildRequest) error
+	// Tag names the image with another repository[:tag] as well.
+	Tag(image string, name string) error
+	// Run runs a bash command in a container of the image, with tempDir mounted at mountPoint.
+	Run(image string, command string) error
+	// Push pushes the image to its registry and returns its digest. A failing push returns a PushError.
+	Push(name string) (string, error)
+	// Labels returns the labels of a local image.
+	Labels(image string) (map[string]string, error)
+}
+
+// BuildRequest is an image to build with the build options applied to its Dockerfile already.
+type BuildRequest struct {
+	Name           string
+	Dockerfile     []byte // the only file of the build context if ContextDir is empty
+	ContextDir     string
+	DockerfileName string // of Dockerfile in ContextDir, Dockerfile if empty
+	Options        BuildOptions
+}
+
+// NewBuilder returns the builder of the docker configs, which passes the output of builds, tests
+// and pushes to handle.
+func NewBuilder(d DockerConfig, handle func(BuildEvent)) (Builder, error) {
+	switch d.Builder {
+	case "", BuilderAPI:
+		client, err := NewDockerClient(d)
+		if err != nil {
+			return nil, err
+		}
+		return &apiBuilder{client: client, handle: handle}, nil
+	case BuilderDocker, BuilderPodman, BuilderBuildah:
+		return &cliBuilder{tool: d.Builder, handle: handle}, nil
+	default:
+		return nil, fmt.Errorf("unknown builder '%s', expected %s, %s, %s or %s",
+			d.Builder, BuilderAPI, BuilderDocker, BuilderPodman, BuilderBuildah)
+	}
+}
@@ -0,0 +1,297 @@
+package pazuzu
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// fakeTool writes an executable standing in for a container tool into a temporary directory in
+// front of PATH. It records its arguments, one call per line, which calls returns.
+func fakeTool(t *testing.T, name string, script string) (calls func() []string, restore func()) {
+	dir, err := ioutil.TempDir("", "pazuzu-fake-"+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callsFile := filepath.Join(dir, "calls")
+	content := "#!/bin/sh\necho \"$@\" >> " + callsFile + "\n" + script + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	restoreEnv := setEnv(t, map[string]string{"PATH": dir + ":" + os.Getenv("PATH")})
+	calls = func() []string {
+		content, _ := ioutil.ReadFile(callsFile)
+		return strings.Split(strings.TrimSpace(string(content)), "\n")
+	}
+	return calls, func() {
+		restoreEnv()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestNewBuilder(t *testing.T) {
+	for name, expected := range map[string]string{
+		"":             "*pazuzu.apiBuilder",
+		BuilderAPI:     "*pazuzu.apiBuilder",
+		BuilderDocker:  "*pazuzu.cliBuilder",
+		BuilderPodman:  "*pazuzu.cliBuilder",
+		BuilderBuildah: "*pazuzu.cliBuilder",
+	} {
+		builder, err := NewBuilder(DockerConfig{Builder: name}, func(BuildEvent) {})
+		if err != nil {
+			t.Fatalf("should not fail for '%s': %s", name, err)
+		}
+		if kind := reflect.TypeOf(builder).String(); kind != expected {
+			t.Errorf("builder '%s' should be %s, was %s", name, expected, kind)
+		}
+	}
+
+	if _, err := NewBuilder(DockerConfig{Builder: "kaniko"}, nil); err == nil {
+		t.Error("should fail for unknown builders")
+	}
+}
+
+func TestCLIBuilderBuild(t *testing.T) {
+	request := BuildRequest{
+		Name:       "ci-image",
+		Dockerfile: []byte(streamDockerfile),
+		Options:    BuildOptions{Args: map[string]string{"VERSION": "1"}, NoCache: true},
+	}
+
+	t.Run("Builds with podman", func(t *testing.T) {
+		calls, restore := fakeTool(t, BuilderPodman, `echo "STEP 1/4: FROM ubuntu"
+echo "STEP 2/4: RUN install curl"
+echo "curl installed" >&2
+echo "STEP 3/4: RUN (install java)  && (install lein)"
+echo "STEP 4/4: CMD /bin/bash"`)
+		defer restore()
+
+		var events []BuildEvent
+		builder := &cliBuilder{tool: BuilderPodman, handle: func(event BuildEvent) {
+			events = append(events, event)
+		}}
+		if err := builder.Build(request); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+
+		args := strings.Fields(calls()[0])
+		context := args[len(args)-1]
+		expectedArgs := []string{"build", "-t", "ci-image", "-f", filepath.Join(context, "Dockerfile"),
+			"--build-arg", "VERSION=1", "--no-cache", context}
+		if !reflect.DeepEqual(args, expectedArgs) {
+			t.Errorf("wrong arguments: %v", args)
+		}
+		if _, err := os.Stat(context); !os.IsNotExist(err) {
+			t.Errorf("temporary build context should be removed: %v", err)
+		}
+		types := []string{}
+		for _, event := range events {
+			types = append(types, event.Type)
+		}
+		expected := []string{BuildStep, BuildFeature, BuildStep, BuildOutput, BuildFeature, BuildStep, BuildStep}
+		if !reflect.DeepEqual(types, expected) {
+			t.Fatalf("wrong events: %v", events)
+		}
+		if !reflect.DeepEqual(events[3].Features, []string{"curl"}) {
+			t.Errorf("output should belong to curl: %v", events[3])
+		}
+	})
+
+	t.Run("Reports the failing step of BuildKit", func(t *testing.T) {
+		calls, restore := fakeTool(t, BuilderDocker, `echo "buildkit=$DOCKER_BUILDKIT" >> "$(dirname "$0")/calls"
+echo "#5 [1/4] FROM ubuntu"
+echo "#6 [3/4] RUN (install java)  && (install lein)"
+echo "#7 [2/4] RUN install curl"
+echo "#7 DONE 0.4s"
+echo "#6 0.213 lein: not found"
+echo "#6 ERROR: process \"/bin/sh -c (install java)  && (install lein)\" did not complete successfully: exit code: 127" >&2
+echo "ERROR: failed to solve: exit code: 127" >&2
+exit 1`)
+		defer restore()
+
+		builder := &cliBuilder{tool: BuilderDocker, handle: func(BuildEvent) {}}
+		err := builder.Build(request)
+		buildErr, ok := err.(*BuildError)
+		if !ok {
+			t.Fatalf("should fail with BuildError: %v", err)
+		}
+		if buildErr.Step != 3 || !reflect.DeepEqual(buildErr.Features, []string{"java", "lein"}) ||
+			!strings.HasSuffix(buildErr.Message, "exit code: 127") {
+			t.Errorf("wrong error: %#v", buildErr)
+		}
+
+		if !strings.HasPrefix(calls()[0], "build --progress=plain -t ci-image") || calls()[1] != "buildkit=1" {
+			t.Errorf("should build with BuildKit: %v", calls())
+		}
+	})
+
+	t.Run("Reports failures before the first step", func(t *testing.T) {
+		_, restore := fakeTool(t, BuilderBuildah, `echo "Error: no context directory" >&2; exit 125`)
+		defer restore()
+
+		builder := &cliBuilder{tool: BuilderBuildah, handle: func(BuildEvent) {}}
+		err := builder.Build(request)
+		if err == nil || err.Error() != "build failed: Error: no context directory" {
+			t.Errorf("wrong error: %v", err)
+		}
+	})
+}
+
+func TestCLIBuilderRun(t *testing.T) {
+	t.Run("Runs a container with podman as root with bash", func(t *testing.T) {
+		calls, restore := fakeTool(t, BuilderPodman, "echo ok")
+		defer restore()
+
+		builder := &cliBuilder{tool: BuilderPodman, handle: func(BuildEvent) {}}
+		if err := builder.Run("ci-image", "bats test.bats"); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		expected := []string{"run --rm -v " + tempDir + ":" + mountPoint + " --entrypoint /bin/bash --user root ci-image -c bats test.bats"}
+		if !reflect.DeepEqual(calls(), expected) {
+			t.Errorf("wrong calls: %v", calls())
+		}
+	})
+
+	t.Run("Creates and removes the container with buildah", func(t *testing.T) {
+		calls, restore := fakeTool(t, BuilderBuildah, `case "$1" in
+from) echo ci-image-working-container ;;
+run) echo "not ok 1 java is installed"; exit 1 ;;
+esac`)
+		defer restore()
+
+		builder := &cliBuilder{tool: BuilderBuildah, handle: func(BuildEvent) {}}
+		err := builder.Run("ci-image", "bats test.bats")
+		if err == nil || !strings.Contains(err.Error(), "not ok 1 java is installed") {
+			t.Errorf("should fail with the test output: %v", err)
+		}
+		expected := []string{
+			"from ci-image",
+			"run -v " + tempDir + ":" + mountPoint + " --user root ci-image-working-container -- /bin/bash -c bats test.bats",
+			"rm ci-image-working-container",
+		}
+		if !reflect.DeepEqual(calls(), expected) {
+			t.Errorf("wrong calls: %v", calls())
+		}
+	})
+}
+
+func TestAPIBuilderRun(t *testing.T) {
+	var config docker.Config
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/containers/create") {
+			json.NewDecoder(r.Body).Decode(&config)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	builder, err := NewBuilder(DockerConfig{Endpoint: server.URL}, func(BuildEvent) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.Run("ci-image", "bats test.bats"); err == nil {
+		t.Error("should fail with the error of the daemon")
+	}
+	if config.Entrypoint == nil || len(config.Entrypoint) != 0 || config.User != "root" {
+		t.Errorf("container should run as root without entrypoint: %v, %s", config.Entrypoint, config.User)
+	}
+}
+
+func TestCLIBuilderOutput(t *testing.T) {
+	t.Run("Reads lines longer than the default buffer", func(t *testing.T) {
+		_, restore := fakeTool(t, BuilderDocker, `head -c 100000 /dev/zero | tr '\0' x; echo
+echo "ERROR: failed to solve" >&2; exit 1`)
+		defer restore()
+
+		var lines []string
+		builder := &cliBuilder{tool: BuilderDocker}
+		last, err := builder.run(exec.Command(BuilderDocker), func(line string) {
+			lines = append(lines, line)
+		})
+		if len(lines) != 2 || len(lines[0]) != 100000 {
+			t.Errorf("all lines should be read, were %d", len(lines))
+		}
+		if err == nil || last != "ERROR: failed to solve" {
+			t.Errorf("should fail with the last line: %s, %v", last, err)
+		}
+	})
+
+	t.Run("Reports lines too long to be read", func(t *testing.T) {
+		_, restore := fakeTool(t, BuilderDocker, `head -c 17000000 /dev/zero | tr '\0' x; echo; echo done`)
+		defer restore()
+
+		builder := &cliBuilder{tool: BuilderDocker}
+		last, err := builder.run(exec.Command(BuilderDocker), func(string) {})
+		if err == nil || !strings.HasPrefix(last, "could not read the output of docker") {
+			t.Errorf("should fail with the read error: %s, %v", last, err)
+		}
+	})
+}
+
+func TestCLIBuilderPush(t *testing.T) {
+	t.Run("Reads the digest from the output of docker", func(t *testing.T) {
+		_, restore := fakeTool(t, BuilderDocker, `echo "5f70bf18a086: Pushed"
+echo "1.0: digest: sha256:4f0a size: 528"`)
+		defer restore()
+
+		builder := &cliBuilder{tool: BuilderDocker, handle: func(BuildEvent) {}}
+		digest, err := builder.Push("registry.example.org/ci-image:1.0")
+		if err != nil || digest != "sha256:4f0a" {
+			t.Errorf("digest should be sha256:4f0a, was %s (%v)", digest, err)
+		}
+	})
+
+	t.Run("Reads the digest file of buildah", func(t *testing.T) {
+		calls, restore := fakeTool(t, BuilderBuildah, `echo "Writing manifest to image destination"
+echo sha256:5e1b > "$3"`)
+		defer restore()
+
+		builder := &cliBuilder{tool: BuilderBuildah, handle: func(BuildEvent) {}}
+		digest, err := builder.Push("registry.example.org/ci-image:1.0")
+		if err != nil || digest != "sha256:5e1b" {
+			t.Errorf("digest should be sha256:5e1b, was %s (%v)", digest, err)
+		}
+		if args := strings.Fields(calls()[0]); len(args) != 4 || args[1] != "--digestfile" {
+			t.Errorf("wrong arguments: %v", args)
+		}
+	})
+
+	t.Run("Returns a PushError", func(t *testing.T) {
+		_, restore := fakeTool(t, BuilderPodman, `echo "Error: unauthorized: authentication required" >&2; exit 125`)
+		defer restore()
+
+		builder := &cliBuilder{tool: BuilderPodman, handle: func(BuildEvent) {}}
+		_, err := builder.Push("registry.example.org/ci-image:1.0")
+		expected := "could not push image registry.example.org/ci-image:1.0: Error: unauthorized: authentication required"
+		if _, ok := err.(*PushError); !ok || err.Error() != expected {
+			t.Errorf("error should be '%s', was '%v'", expected, err)
+		}
+	})
+}
+
+func TestCLIBuilderLabels(t *testing.T) {
+	calls, restore := fakeTool(t, BuilderBuildah, `echo '{"org.zalando.pazuzu.features":"curl,java"}'`)
+	defer restore()
+
+	builder := &cliBuilder{tool: BuilderBuildah, handle: func(BuildEvent) {}}
+	labels, err := builder.Labels("ci-image")
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+	if labels[LabelFeatures] != "curl,java" {
+		t.Errorf("wrong labels: %v", labels)
+	}
+	if calls()[0] != "inspect --type image --format {{json .OCIv1.Config.Labels}} ci-image" {
+		t.Errorf("wrong calls: %v", calls())
+	}
+}
@@ -0,0 +1,136 @@
+package pazuzu
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/builder/dockerfile/command"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// BuildOptions are the options of DockerBuild, defaults are kept in the build section of the Pazuzufile.
+type BuildOptions struct {
+	Tags     []string          `yaml:"tags,omitempty"` // names of the image, repository[:tag]
+	Args     map[string]string `yaml:"args,omitempty"` // build arguments
+	NoCache  bool              `yaml:"no_cache,omitempty"`
+	Pull     bool              `yaml:"pull,omitempty"`     // pull newer versions of the base images
+	Labels   map[string]string `yaml:"labels,omitempty"`   // added to the built stage
+	Target   string            `yaml:"target,omitempty"`   // stage to build, the last one if empty
+	Platform string            `yaml:"platform,omitempty"` // os/arch[/variant] of the base images, e.g. linux/arm64
+}
+
+var buildPlatformRegexp = regexp.MustCompile(`^[a-z0-9_]+(/[a-z0-9_]+){1,2}$`)
+
+// Override returns the options with the given ones taking precedence. Arguments and labels are
+// merged, given tags replace the others. NoCache and Pull are set if set in either, as false
+// can not be told apart from not given.
+func (o BuildOptions) Override(other BuildOptions) BuildOptions {
+	result := o
+	if len(other.Tags) > 0 {
+		result.Tags = other.Tags
+	}
+	result.Args = mergeLabels(o.Args, other.Args)
+	result.Labels = mergeLabels(o.Labels, other.Labels)
+	result.NoCache = o.NoCache || other.NoCache
+	result.Pull = o.Pull || other.Pull
+	if other.Target != "" {
+		result.Target = other.Target
+	}
+	if other.Platform != "" {
+		result.Platform = other.Platform
+	}
+	return result
+}
+
+// buildArgs returns the build arguments in the order of their names.
+func (o BuildOptions) buildArgs() []docker.BuildArg {
+	var args []docker.BuildArg
+	for _, name := range sortedKeys(o.Args) {
+		args = append(args, docker.BuildArg{Name: name, Value: o.Args[name]})
+	}
+	return args
+}
+
+// rewriteDockerfile applies target, labels and platform to the Dockerfile, as the Docker client
+// has no options for them: stages after the target are left out, the labels are added to the end
+// of the built stage and FROM instructions get the platform, unless they have one already.
+func (o BuildOptions) rewriteDockerfile(dockerfile []byte) ([]byte, error) {
+	if o.Target == "" && len(o.Labels) == 0 && o.Platform == "" {
+		return dockerfile, nil
+	}
+	if o.Platform != "" && !buildPlatformRegexp.MatchString(o.Platform) {
+		return nil, fmt.Errorf("invalid platform '%s', expected os/arch[/variant]", o.Platform)
+	}
+
+	ast, err := parseDockerfile(string(dockerfile))
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(string(dockerfile), "\n"), "\n")
+
+	// 1-based line of the end of the built stage, the last line by default
+	end := len(lines)
+	targetFound := o.Target == ""
+	for _, node := range ast.Children {
+		if node.Value != command.From {
+			continue
+		}
+		if targetFound && o.Target != "" {
+			end = node.StartLine - 1
+			break
+		}
+		if o.Target != "" && node.Next != nil {
+			fields := strings.Fields(node.Next.Value)
+			targetFound = len(fields) == 3 && strings.EqualFold(fields[1], "as") && fields[2] == o.Target
+		}
+		if o.Platform != "" && !hasPlatformFlag(node.Flags) {
+			line := lines[node.StartLine-1]
+			i := strings.Index(strings.ToUpper(line), "FROM")
+			lines[node.StartLine-1] = line[:i+len("FROM")] + " --platform=" + o.Platform + line[i+len("FROM"):]
+		}
+	}
+	if !targetFound {
+		return nil, fmt.Errorf("target stage '%s' not found in Dockerfile", o.Target)
+	}
+	lines = lines[:end]
+
+	for _, key := range sortedKeys(o.Labels) {
+		if !labelKeyRegexp.MatchString(key) || strings.ContainsAny(o.Labels[key], "\r\n") {
+			return nil, fmt.Errorf("invalid label '%s'", key)
+		}
+		lines = append(lines, fmt.Sprintf("LABEL %s=%s", key, quoteValue(o.Labels[key])))
+	}
+
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+func hasPlatformFlag(flags []string) bool {
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, "--platform=") {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTag splits names of images like registry:5000/java:8 into repository and tag.
+func splitTag(name string) (repository string, tag string) {
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// ParseKeyValues parses values given as key=value, such as build arguments or labels.
+func ParseKeyValues(values []string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid value '%s', expected key=value", value)
+		}
+		result[parts[0]] = parts[1]
+	}
+	return result, nil
+}
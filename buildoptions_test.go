@@ -0,0 +1,154 @@
+package pazuzu
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const stagesDockerfile = `FROM ubuntu AS pazuzu-build
+
+# jdk
+
+RUN download jdk
+FROM --platform=linux/amd64 ubuntu
+
+# jdk (artifacts)
+
+COPY --from=pazuzu-build /opt/jdk /opt/jdk
+`
+
+func TestRewriteDockerfile(t *testing.T) {
+	t.Run("Keeps the Dockerfile without options", func(t *testing.T) {
+		dockerfile, err := BuildOptions{NoCache: true, Tags: []string{"java"}}.rewriteDockerfile([]byte(stagesDockerfile))
+		if err != nil || string(dockerfile) != stagesDockerfile {
+			t.Errorf("Dockerfile should be kept: %s, %v", dockerfile, err)
+		}
+	})
+
+	t.Run("Builds the target stage with labels", func(t *testing.T) {
+		options := BuildOptions{Target: "pazuzu-build", Labels: map[string]string{"team": "ci", "stage": "build jdk"}}
+		dockerfile, err := options.rewriteDockerfile([]byte(stagesDockerfile))
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+
+		expected := `FROM ubuntu AS pazuzu-build
+
+# jdk
+
+RUN download jdk
+LABEL stage="build jdk"
+LABEL team=ci
+`
+		if string(dockerfile) != expected {
+			t.Errorf("wrong Dockerfile:\n%s", dockerfile)
+		}
+	})
+
+	t.Run("Sets the platform of base images", func(t *testing.T) {
+		dockerfile, err := BuildOptions{Platform: "linux/arm64"}.rewriteDockerfile([]byte(stagesDockerfile))
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if !strings.HasPrefix(string(dockerfile), "FROM --platform=linux/arm64 ubuntu AS pazuzu-build\n") ||
+			!strings.Contains(string(dockerfile), "\nFROM --platform=linux/amd64 ubuntu\n") {
+			t.Errorf("wrong Dockerfile:\n%s", dockerfile)
+		}
+	})
+
+	t.Run("Fails for invalid options", func(t *testing.T) {
+		for _, options := range []BuildOptions{
+			{Target: "missing"},
+			{Platform: "arm64"},
+			{Labels: map[string]string{"team name": "ci"}},
+		} {
+			if _, err := options.rewriteDockerfile([]byte(stagesDockerfile)); err == nil {
+				t.Errorf("should fail for %v", options)
+			}
+		}
+	})
+}
+
+func TestBuildOptionsOverride(t *testing.T) {
+	defaults := BuildOptions{
+		Tags:   []string{"ci:latest", "ci:1.0"},
+		Args:   map[string]string{"http_proxy": "http://proxy", "version": "1"},
+		Pull:   true,
+		Target: "final",
+	}
+	options := defaults.Override(BuildOptions{
+		Args:     map[string]string{"version": "2"},
+		NoCache:  true,
+		Platform: "linux/arm64",
+	})
+
+	expected := BuildOptions{
+		Tags:     []string{"ci:latest", "ci:1.0"},
+		Args:     map[string]string{"http_proxy": "http://proxy", "version": "2"},
+		NoCache:  true,
+		Pull:     true,
+		Labels:   map[string]string{},
+		Target:   "final",
+		Platform: "linux/arm64",
+	}
+	if !reflect.DeepEqual(options, expected) {
+		t.Errorf("wrong options: %v", options)
+	}
+
+	if options := defaults.Override(BuildOptions{Tags: []string{"ci:2.0"}}); !reflect.DeepEqual(options.Tags, []string{"ci:2.0"}) {
+		t.Errorf("tags should be replaced: %v", options.Tags)
+	}
+}
+
+func TestParseKeyValues(t *testing.T) {
+	values, err := ParseKeyValues([]string{"version=1.8", "opts=-Xmx1g -Dx=y", "empty="})
+	expected := map[string]string{"version": "1.8", "opts": "-Xmx1g -Dx=y", "empty": ""}
+	if err != nil || !reflect.DeepEqual(values, expected) {
+		t.Errorf("wrong values: %v, %v", values, err)
+	}
+
+	for _, value := range []string{"version", "=1.8"} {
+		if _, err := ParseKeyValues([]string{value}); err == nil {
+			t.Errorf("should fail for %s", value)
+		}
+	}
+}
+
+func TestSplitTag(t *testing.T) {
+	examples := map[string][2]string{
+		"java":                     {"java", ""},
+		"java:8":                   {"java", "8"},
+		"localhost:5000/java":      {"localhost:5000/java", ""},
+		"localhost:5000/ci/java:8": {"localhost:5000/ci/java", "8"},
+	}
+	for name, expected := range examples {
+		if repository, tag := splitTag(name); repository != expected[0] || tag != expected[1] {
+			t.Errorf("wrong split of %s: %s, %s", name, repository, tag)
+		}
+	}
+}
+
+func TestReadBuildOptions(t *testing.T) {
+	pazuzuFile, err := Read(strings.NewReader(`---
+base: ubuntu
+features: [java]
+build:
+  tags: ["ci:latest", "registry.example.org/ci:1.0"]
+  args: {http_proxy: "http://proxy:3128"}
+  pull: true
+  target: final`))
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	expected := &BuildOptions{
+		Tags:   []string{"ci:latest", "registry.example.org/ci:1.0"},
+		Args:   map[string]string{"http_proxy": "http://proxy:3128"},
+		Pull:   true,
+		Target: "final",
+	}
+	if !reflect.DeepEqual(pazuzuFile.Build, expected) {
+		t.Errorf("wrong build options: %v", pazuzuFile.Build)
+	}
+}
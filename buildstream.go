@@ -0,0 +1,272 @@
+package pazuzu
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Types of BuildEvent.
+const (
+	BuildStep    = "step"    // the daemon started a step
+	BuildFeature = "feature" // the step belongs to other features than the previous one
+	BuildOutput  = "output"  // output of the daemon or of the step
+	BuildFailure = "error"   // the build failed
+)
+
+// BuildEvent is an event of the build stream of the Docker daemon.
+type BuildEvent struct {
+	Type        string
+	Step        int      // 1-based, 0 before the first step
+	Instruction string   // instruction of the step as written in the Dockerfile
+	Features    []string // features the instruction comes from, none for other instructions
+	Message     string   // line of output or error message
+}
+
+// BuildError is returned when the Docker daemon fails to build an image.
+type BuildError struct {
+	Step        int // 0 if the build failed before the first step
+	Instruction string
+	Features    []string
+	Message     string
+}
+
+func (e *BuildError) Error() string {
+	if e.Step == 0 {
+		return "build failed: " + e.Message
+	}
+
+	where := fmt.Sprintf("step %d", e.Step)
+	if len(e.Features) > 0 {
+		where += fmt.Sprintf(" of feature '%s'", strings.Join(e.Features, "', '"))
+	}
+	return fmt.Sprintf("build failed at %s:\n  %s\n%s", where, e.Instruction, e.Message)
+}
+
+// ImageTestError is returned when the test spec fails for a built image.
+type ImageTestError struct {
+	Image string
+	Err   error
+}
+
+func (e *ImageTestError) Error() string {
+	return fmt.Sprintf("tests of image %s failed: %s", e.Image, e.Err)
+}
+
+var (
+	// step lines of the Docker daemon and of podman and buildah
+	stepRegexp = regexp.MustCompile(`^(?:Step|STEP) (\d+)(/\d+)? ?: (.*)$`)
+	// plain progress of BuildKit: steps and errors of the numbered build vertices
+	buildKitStepRegexp  = regexp.MustCompile(`^#(\d+) \[[^\]]*\d+/\d+\] (.*)$`)
+	buildKitErrorRegexp = regexp.MustCompile(`^#(\d+) ERROR: (.*)$`)
+	// comments written by DockerfileWriter in front of the instructions of features
+	featureCommentRegexp = regexp.MustCompile(`^# ([^\s,]+(, [^\s,]+)*)( \(artifacts\))?$`)
+)
+
+// buildInstruction is an instruction of a Dockerfile, in the order of the build steps.
+type buildInstruction struct {
+	original string
+	features []string
+}
+
+// buildInstructions reads the instructions of a Dockerfile and the features they come from,
+// as named by the comments in front of them. Other comments end the instructions of a feature.
+func buildInstructions(dockerfile []byte) []buildInstruction {
+	ast, err := parseDockerfile(string(dockerfile))
+	if err != nil {
+		return nil
+	}
+
+	var (
+		featuresAt = map[int][]string{} // by 1-based line
+		current    []string
+	)
+	for i, line := range strings.Split(string(dockerfile), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			current = nil
+			if match := featureCommentRegexp.FindStringSubmatch(line); match != nil {
+				current = strings.Split(match[1], ", ")
+			}
+		}
+		featuresAt[i+1] = current
+	}
+
+	instructions := make([]buildInstruction, 0, len(ast.Children))
+	for _, node := range ast.Children {
+		instructions = append(instructions, buildInstruction{
+			original: node.Original,
+			features: featuresAt[node.StartLine],
+		})
+	}
+	return instructions
+}
+
+// buildMessage is a message of the JSON build stream.
+type buildMessage struct {
+	Stream      string `json:"stream"`
+	Status      string `json:"status"`
+	Progress    string `json:"progress"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// buildProgress turns the output of a build into events, naming the step and the features of
+// every line. The first error is kept as BuildError.
+type buildProgress struct {
+	instructions []buildInstruction
+	handle       func(BuildEvent)
+	current      BuildEvent
+	features     string
+	vertices     map[string]int // steps of the BuildKit vertices
+	err          *BuildError
+}
+
+func newBuildProgress(instructions []buildInstruction, handle func(BuildEvent)) *buildProgress {
+	return &buildProgress{instructions: instructions, handle: handle, vertices: map[string]int{}}
+}
+
+// line handles a line of output, which may start a step.
+func (b *buildProgress) line(line string) {
+	if match := stepRegexp.FindStringSubmatch(line); match != nil {
+		step, _ := strconv.Atoi(match[1])
+		b.step(step, match[3], line)
+		return
+	}
+	if match := buildKitStepRegexp.FindStringSubmatch(line); match != nil {
+		if _, ok := b.vertices[match[1]]; !ok {
+			b.vertices[match[1]] = b.findStep(match[2])
+			b.step(b.vertices[match[1]], match[2], line)
+			return
+		}
+	}
+	if match := buildKitErrorRegexp.FindStringSubmatch(line); match != nil {
+		if step, ok := b.vertices[match[1]]; ok && step != b.current.Step {
+			b.step(step, b.current.Instruction, line)
+		}
+		b.fail(match[2])
+		return
+	}
+
+	event := b.current
+	event.Type, event.Message = BuildOutput, line
+	b.handle(event)
+}
+
+// findStep returns the 1-based step of an instruction as printed by BuildKit, the steps after
+// the current one come first. It is 0 for instructions not found in the Dockerfile.
+func (b *buildProgress) findStep(instruction string) int {
+	for i := range b.instructions {
+		index := (b.current.Step + i) % len(b.instructions)
+		if b.instructions[index].original == instruction {
+			return index + 1
+		}
+	}
+	return 0
+}
+
+func (b *buildProgress) step(step int, instruction string, line string) {
+	b.current = BuildEvent{Step: step, Instruction: instruction}
+	if step >= 1 && step <= len(b.instructions) {
+		b.current.Instruction = b.instructions[step-1].original
+		b.current.Features = b.instructions[step-1].features
+	}
+	if names := strings.Join(b.current.Features, ", "); names != b.features {
+		b.features = names
+		if names != "" {
+			event := b.current
+			event.Type = BuildFeature
+			b.handle(event)
+		}
+	}
+	event := b.current
+	event.Type, event.Message = BuildStep, line
+	b.handle(event)
+}
+
+// fail reports an error of the current step.
+func (b *buildProgress) fail(message string) {
+	event := b.current
+	event.Type, event.Message = BuildFailure, message
+	b.handle(event)
+	if b.err == nil {
+		b.err = &BuildError{
+			Step:        b.current.Step,
+			Instruction: b.current.Instruction,
+			Features:    b.current.Features,
+			Message:     message,
+		}
+	}
+}
+
+// drain reads the rest of a build, push or tool output, so the writer of it is not blocked when the
+// reading stops early.
+func drain(reader io.Reader) {
+	io.Copy(ioutil.Discard, reader)
+}
+
+// readBuildStream reads the JSON build stream of the Docker daemon to its end and passes its events
+// to handle. It returns a BuildError for the first error of the stream.
+func readBuildStream(reader io.Reader, instructions []buildInstruction, handle func(BuildEvent)) error {
+	defer drain(reader)
+
+	var (
+		decoder  = json.NewDecoder(reader)
+		progress = newBuildProgress(instructions, handle)
+		pending  string // stream output without line break so far
+	)
+	for {
+		var message buildMessage
+		if err := decoder.Decode(&message); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("could not read the build stream: %s", err)
+		}
+
+		if message.Stream != "" {
+			lines := strings.Split(pending+message.Stream, "\n")
+			pending = lines[len(lines)-1]
+			for _, line := range lines[:len(lines)-1] {
+				progress.line(line)
+			}
+		}
+		// progress updates of pulled images are left out
+		if message.Status != "" && message.Progress == "" {
+			progress.line(message.Status)
+		}
+		if message.Error != "" || message.ErrorDetail.Message != "" {
+			text := message.ErrorDetail.Message
+			if text == "" {
+				text = message.Error
+			}
+			progress.fail(text)
+		}
+	}
+	if pending != "" {
+		progress.line(pending)
+	}
+
+	if progress.err != nil {
+		return progress.err
+	}
+	return nil
+}
+
+// printBuildEvents writes the output of the build, naming the features in front of their steps.
+// Errors are left to the caller.
+func printBuildEvents(writer io.Writer) func(BuildEvent) {
+	return func(event BuildEvent) {
+		switch event.Type {
+		case BuildFeature:
+			fmt.Fprintf(writer, "==> %s\n", strings.Join(event.Features, ", "))
+		case BuildStep, BuildOutput:
+			fmt.Fprintln(writer, event.Message)
+		}
+	}
+}
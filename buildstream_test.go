@@ -0,0 +1,107 @@
+package pazuzu
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const streamDockerfile = `FROM ubuntu
+
+# curl
+
+RUN install curl
+# java, lein
+RUN (install java) \
+ && (install lein)
+# image configuration
+
+CMD /bin/bash
+`
+
+func TestBuildInstructions(t *testing.T) {
+	expected := []buildInstruction{
+		{original: "FROM ubuntu"},
+		{original: "RUN install curl", features: []string{"curl"}},
+		{original: "RUN (install java)  && (install lein)", features: []string{"java", "lein"}},
+		{original: "CMD /bin/bash"},
+	}
+	if instructions := buildInstructions([]byte(streamDockerfile)); !reflect.DeepEqual(instructions, expected) {
+		t.Errorf("wrong instructions: %v", instructions)
+	}
+}
+
+func TestReadBuildStream(t *testing.T) {
+	instructions := buildInstructions([]byte(streamDockerfile))
+
+	read := func(stream string) ([]BuildEvent, error) {
+		var events []BuildEvent
+		err := readBuildStream(strings.NewReader(stream), instructions, func(event BuildEvent) {
+			events = append(events, event)
+		})
+		return events, err
+	}
+
+	t.Run("Parses steps, features and output", func(t *testing.T) {
+		events, err := read(`{"stream":"Step 1/4 : FROM ubuntu\n"}
+{"stream":" ---> 0ef2e08ed3fa\n"}
+{"stream":"Step 2/4 : RUN install curl\n"}
+{"stream":"curl "}
+{"stream":"installed\n"}
+{"stream":"Step 3/4 : RUN (install java)  && (install lein)\n"}
+{"status":"Downloading","progress":"[==>  ]"}
+{"stream":"Successfully built 2e7ee9d87cfa\n"}`)
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+
+		types := []string{}
+		for _, event := range events {
+			types = append(types, event.Type)
+		}
+		expected := []string{BuildStep, BuildOutput, BuildFeature, BuildStep, BuildOutput, BuildFeature, BuildStep, BuildOutput}
+		if !reflect.DeepEqual(types, expected) {
+			t.Fatalf("wrong events: %v", events)
+		}
+		if events[4].Message != "curl installed" || !reflect.DeepEqual(events[4].Features, []string{"curl"}) {
+			t.Errorf("wrong output event: %v", events[4])
+		}
+	})
+
+	t.Run("Reports the failing step with its features", func(t *testing.T) {
+		_, err := read(`{"stream":"Step 1/4 : FROM ubuntu\n"}
+{"stream":"Step 3/4 : RUN (install java)  && (install lein)\n"}
+{"stream":"lein: not found\n"}
+{"errorDetail":{"code":127,"message":"The command '/bin/sh -c (install java)  && (install lein)' returned a non-zero code: 127"},"error":"The command returned a non-zero code: 127"}`)
+
+		buildErr, ok := err.(*BuildError)
+		if !ok {
+			t.Fatalf("should fail with BuildError: %v", err)
+		}
+		expected := &BuildError{
+			Step:        3,
+			Instruction: "RUN (install java)  && (install lein)",
+			Features:    []string{"java", "lein"},
+			Message:     "The command '/bin/sh -c (install java)  && (install lein)' returned a non-zero code: 127",
+		}
+		if !reflect.DeepEqual(buildErr, expected) {
+			t.Errorf("wrong error: %v", buildErr)
+		}
+		if !strings.HasPrefix(err.Error(), "build failed at step 3 of feature 'java', 'lein':\n  RUN (install java)") {
+			t.Errorf("wrong message: %s", err)
+		}
+	})
+
+	t.Run("Reports errors before the first step", func(t *testing.T) {
+		_, err := read(`{"errorDetail":{"message":"Cannot locate specified Dockerfile"},"error":"Cannot locate specified Dockerfile"}`)
+		if err == nil || err.Error() != "build failed: Cannot locate specified Dockerfile" {
+			t.Errorf("wrong error: %v", err)
+		}
+	})
+
+	t.Run("Fails for invalid streams", func(t *testing.T) {
+		if _, err := read(`{"stream":`); err == nil {
+			t.Error("should fail")
+		}
+	})
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"text/tabwriter"
 
 	"github.com/satori/go.uuid"
 	"github.com/urfave/cli"
@@ -29,20 +31,151 @@ func buildFeatures(c *cli.Context) error {
 		return fmt.Errorf("Error during attempt to read docker file:%s", err)
 	}
 
+	contextDir := directory
+	if contextDir == "" {
+		contextDir = "."
+	}
+
 	p := pazuzu.Pazuzu{StorageReader: storageReader,
-		DockerEndpoint: "unix:///var/run/docker.sock",
-		Dockerfile:     dat,
+		Docker:     dockerConfig(c),
+		Dockerfile: dat,
+		ContextDir: contextDir,
+	}
+
+	p.Build, err = buildOptions(c, getAbsoluteFilePath(directory, PazuzufileName))
+	if err != nil {
+		return err
 	}
 
-	name := ""
-	if c.String("name") != "" {
-		name = c.String("name")
-	} else {
+	name := c.String("name")
+	if name == "" && len(p.Build.Tags) > 0 {
+		name = p.Build.Tags[0]
+	}
+	if name == "" {
+		if c.Bool("push") {
+			return fmt.Errorf("--push requires a name or tag of the image")
+		}
 		name = strings.Replace(uuid.NewV1().String(), "-", "", -1)
 	}
-	err2 := p.DockerBuild(name)
-	if err2 != nil {
-		return fmt.Errorf("should not fail: %s", err2)
+	// build and test failures are returned as they are, they name the failing step or tests
+	if err := p.DockerBuild(name); err != nil {
+		return err
+	}
+
+	if !c.Bool("push") {
+		return nil
+	}
+	return pushAndRecord(&p, directory, append([]string{name}, p.Build.Tags...))
+}
+
+// Pushes docker images, the tags of the Pazuzufile by default.
+func pushImages(c *cli.Context) error {
+	directory := c.String(directoryOption)
+	names := []string(c.Args())
+	if len(names) == 0 {
+		if pazuzuFile, ok := readPazuzuFile(getAbsoluteFilePath(directory, PazuzufileName)); ok && pazuzuFile.Build != nil {
+			names = pazuzuFile.Build.Tags
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no images to push, give their names or set the tags of the Pazuzufile")
+	}
+
+	p := pazuzu.Pazuzu{Docker: dockerConfig(c)}
+	return pushAndRecord(&p, directory, names)
+}
+
+// pushAndRecord pushes the images once each and records their digests in the directory.
+func pushAndRecord(p *pazuzu.Pazuzu, directory string, names []string) error {
+	digests := map[string]string{}
+	for _, name := range names {
+		if _, ok := digests[name]; ok {
+			continue
+		}
+		fmt.Printf("Pushing %s\n", name)
+		digest, err := p.DockerPush(name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Pushed %s@%s\n", name, digest)
+		digests[name] = digest
+	}
+
+	if directory == "" {
+		directory = "."
+	}
+	return pazuzu.RecordDigests(directory, digests)
+}
+
+// buildOptions returns the build options of the Pazuzufile, if there is one, overridden by the
+// options of the command line.
+func buildOptions(c *cli.Context, pazuzufilePath string) (pazuzu.BuildOptions, error) {
+	var options pazuzu.BuildOptions
+	if pazuzuFile, ok := readPazuzuFile(pazuzufilePath); ok && pazuzuFile.Build != nil {
+		options = *pazuzuFile.Build
+	}
+
+	args, err := pazuzu.ParseKeyValues(c.StringSlice("build-arg"))
+	if err != nil {
+		return options, fmt.Errorf("--build-arg: %s", err)
+	}
+	labels, err := pazuzu.ParseKeyValues(c.StringSlice("label"))
+	if err != nil {
+		return options, fmt.Errorf("--label: %s", err)
+	}
+
+	options = options.Override(pazuzu.BuildOptions{
+		Tags:     c.StringSlice("tag"),
+		Args:     args,
+		Labels:   labels,
+		Target:   c.String("target"),
+		Platform: c.String("platform"),
+	})
+	// flags given as --no-cache=false turn off the defaults
+	if c.IsSet("no-cache") {
+		options.NoCache = c.Bool("no-cache")
+	}
+	if c.IsSet("pull") {
+		options.Pull = c.Bool("pull")
+	}
+	return options, nil
+}
+
+// dockerConfig returns the docker configs, overridden by the environment variables of the Docker
+// client and by the --docker-endpoint and --builder options, in this order.
+func dockerConfig(c *cli.Context) pazuzu.DockerConfig {
+	config := pazuzu.GetConfig().Docker.WithEnv()
+	if endpoint := c.GlobalString("docker-endpoint"); endpoint != "" {
+		config.Endpoint = endpoint
+	}
+	if builder := c.GlobalString("builder"); builder != "" {
+		config.Builder = builder
+	}
+	return config
+}
+
+// Shows the features of a docker image, read from its labels.
+func inspectImage(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		return pazuzu.ErrTooFewOrManyParameters
+	}
+
+	p := pazuzu.Pazuzu{Docker: dockerConfig(c)}
+	image, err := p.InspectImage(c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Base: %s\n", image.Base)
+	if image.Version != "" {
+		fmt.Printf("Composed by pazuzu %s\n", image.Version)
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Name \tUpdated \tHash\n")
+	for _, feature := range image.Features {
+		fmt.Fprintf(w, "%s \t%s \t%s\n", feature.Name, feature.UpdatedAt, feature.Hash)
 	}
-	return nil
+	return w.Flush()
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestBuildOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pazuzu_build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, PazuzufileName)
+	if err := ioutil.WriteFile(path, []byte("base: ubuntu\nbuild:\n  no_cache: true\n  pull: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	context := func(args ...string) *cli.Context {
+		set := flag.NewFlagSet("build", flag.ContinueOnError)
+		for _, f := range buildFlags {
+			f.Apply(set)
+		}
+		if err := set.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return cli.NewContext(nil, set, nil)
+	}
+
+	t.Run("Keeps the defaults of the Pazuzufile", func(t *testing.T) {
+		options, err := buildOptions(context(), path)
+		if err != nil || !options.NoCache || !options.Pull {
+			t.Errorf("no_cache and pull should be kept: %v, %v", options, err)
+		}
+	})
+
+	t.Run("Turns off the defaults of the Pazuzufile", func(t *testing.T) {
+		options, err := buildOptions(context("--no-cache=false", "--pull=false"), path)
+		if err != nil || options.NoCache || options.Pull {
+			t.Errorf("no_cache and pull should be turned off: %v, %v", options, err)
+		}
+	})
+}
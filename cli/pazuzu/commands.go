@@ -12,6 +12,7 @@ import (
 
 const (
 	PazuzufileName  = "Pazuzufile"
+	LockfileName    = "Pazuzufile.lock"
 	DockerfileName  = "Dockerfile"
 	directoryOption = "directory"
 )
@@ -119,6 +120,12 @@ var searchCmd = cli.Command{
 	Name:      "search",
 	Usage:     "search for features in registry",
 	ArgsUsage: "[regexp] - Regexp to be used for feature lookup",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "b, base",
+			Usage: "Only show features supporting the base image or platform `BASE`, e.g. ubuntu:16.04",
+		},
+	},
 	Action: func(c *cli.Context) error {
 		sc, err := pazuzu.GetStorageReader(*pazuzu.GetConfig())
 		if err != nil {
@@ -135,16 +142,33 @@ var searchCmd = cli.Command{
 		if err != nil {
 			return fmt.Errorf("could not search for features: %s", err.Error())
 		}
+		if base := c.String("base"); base != "" {
+			features, err = filterByBase(features, base)
+			if err != nil {
+				return err
+			}
+		}
 
 		if len(features) == 0 {
 			fmt.Println("no features found")
 			return nil
 		}
 
+		// features of layered storage show where they come from
+		withSource := features[0].Source != ""
+
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.AlignRight)
-		fmt.Fprintf(w, "Name \tAuthor \tDescription\n")
+		if withSource {
+			fmt.Fprintf(w, "Name \tAuthor \tSource \tDescription\n")
+		} else {
+			fmt.Fprintf(w, "Name \tAuthor \tDescription\n")
+		}
 		for _, f := range features {
-			fmt.Fprintf(w, "%s \t%s \t%s\n", f.Name, f.Author, f.Description)
+			if withSource {
+				fmt.Fprintf(w, "%s \t%s \t%s \t%s\n", f.Name, f.Author, f.Source, f.Description)
+			} else {
+				fmt.Fprintf(w, "%s \t%s \t%s\n", f.Name, f.Author, f.Description)
+			}
 		}
 
 		w.Flush()
@@ -170,6 +194,22 @@ var composeFlags = []cli.Flag{
 		Name:  "b, base",
 		Usage: "Sets the base docker image to `BASE`, instead of the one from the configuration",
 	},
+	cli.BoolFlag{
+		Name:  "frozen",
+		Usage: "Fail if the resolved features differ from Pazuzufile.lock in any way",
+	},
+	cli.BoolFlag{
+		Name:  "update",
+		Usage: "Update Pazuzufile.lock with changed content of the locked features",
+	},
+	cli.StringFlag{
+		Name:  "base-platform",
+		Usage: "Sets the `PLATFORM` of the base image as family[:version] if not detected from its name, kept in Pazuzufile",
+	},
+	cli.BoolFlag{
+		Name:  "optimize",
+		Usage: "Merge RUN instructions and package installs of the features into fewer layers, kept in Pazuzufile",
+	},
 }
 
 var composeCmd = cli.Command{
@@ -177,7 +217,8 @@ var composeCmd = cli.Command{
 	Usage:     "Compose Pazuzufile and Dockerfile out of the selected features",
 	ArgsUsage: " ", // Do not show arguments
 	Description: "Compose step takes list of features as input, validates feature dependencies" +
-		" and creates both Pazuzufile and Dockerfile.",
+		" and creates Pazuzufile, Pazuzufile.lock and Dockerfile. Without -a or -i the features" +
+		" of an existing Pazuzufile are composed again.",
 	Flags:  composeFlags,
 	Action: composeAction,
 }
@@ -191,6 +232,38 @@ var buildFlags = []cli.Flag{
 		Name:  "n, name",
 		Usage: "Sets a name for docker image",
 	},
+	cli.StringSliceFlag{
+		Name:  "t, tag",
+		Usage: "Tags the image as `NAME[:TAG]`, can be repeated, replaces the tags of the Pazuzufile",
+	},
+	cli.StringSliceFlag{
+		Name:  "build-arg",
+		Usage: "Sets the build argument `NAME=VALUE`, can be repeated",
+	},
+	cli.StringSliceFlag{
+		Name:  "label",
+		Usage: "Adds the label `KEY=VALUE` to the image, can be repeated",
+	},
+	cli.BoolFlag{
+		Name:  "no-cache",
+		Usage: "Do not use the cache of the Docker daemon",
+	},
+	cli.BoolFlag{
+		Name:  "pull",
+		Usage: "Always pull newer versions of the base images",
+	},
+	cli.StringFlag{
+		Name:  "target",
+		Usage: "Builds the stage `STAGE` of the Dockerfile, instead of the last one",
+	},
+	cli.StringFlag{
+		Name:  "platform",
+		Usage: "Builds for `OS/ARCH` (e.g. linux/arm64), requires a daemon supporting FROM --platform",
+	},
+	cli.BoolFlag{
+		Name:  "push",
+		Usage: "Pushes the image and its tags after a successful build",
+	},
 }
 
 var buildCmd = cli.Command{
@@ -200,3 +273,84 @@ var buildCmd = cli.Command{
 	Flags:     buildFlags,
 	Action:    buildFeatures,
 }
+
+var pushFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "d, directory",
+		Usage: "Sets the path of the Pazuzufile, its tags are pushed if no images are given",
+	},
+}
+
+var pushCmd = cli.Command{
+	Name:      "push",
+	Usage:     "Push Docker images to their registries",
+	ArgsUsage: "[<image>...]",
+	Description: "Push step pushes local images with the credentials of the Docker client," +
+		" including its credential helpers, and records their digests in image.digests.",
+	Flags:  pushFlags,
+	Action: pushImages,
+}
+
+var inspectCmd = cli.Command{
+	Name:      "inspect",
+	Usage:     "Show the features a Docker image was composed of",
+	ArgsUsage: "<image>",
+	Action:    inspectImage,
+}
+
+var serveFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "l, listen",
+		Value: ":8080",
+		Usage: "Sets the `ADDRESS` to listen on",
+	},
+	cli.StringFlag{
+		Name:  "r, root",
+		Usage: "Sets the feature directory to serve, instead of local.root from the configuration",
+	},
+	cli.BoolFlag{
+		Name:  "read-only",
+		Usage: "Refuses to create, update and delete features",
+	},
+}
+
+var serveCmd = cli.Command{
+	Name:      "serve",
+	Usage:     "Serve features of a local feature directory as a registry",
+	ArgsUsage: " ",
+	Description: "Serve step runs a registry with the same API as pazuzu-registry on top of a" +
+		" feature directory in the local storage layout, to be used by the registry storage.",
+	Flags:  serveFlags,
+	Action: serveFeatures,
+}
+
+var featurePublishCmd = cli.Command{
+	Name:      "publish",
+	Usage:     "Publish a new feature from a feature folder",
+	ArgsUsage: "DIRECTORY - Folder named after the feature, with meta.yml, Dockerfile and test.bats",
+	Action:    publishFeature,
+}
+
+var featureUpdateCmd = cli.Command{
+	Name:      "update",
+	Usage:     "Update an existing feature from a feature folder",
+	ArgsUsage: "DIRECTORY - Folder named after the feature, with meta.yml, Dockerfile and test.bats",
+	Action:    updateFeature,
+}
+
+var featureDeleteCmd = cli.Command{
+	Name:      "delete",
+	Usage:     "Delete a feature from the storage",
+	ArgsUsage: "NAME - Name of the feature",
+	Action:    deleteFeature,
+}
+
+var featureCmd = cli.Command{
+	Name:  "feature",
+	Usage: "Manage features in the configured storage",
+	Subcommands: []cli.Command{
+		featurePublishCmd,
+		featureUpdateCmd,
+		featureDeleteCmd,
+	},
+}
@@ -13,13 +13,18 @@ var composeAction = func(c *cli.Context) error {
 		initFeatures       = getFeaturesList(c.String("init"))
 		addFeatures        = getFeaturesList(c.String("add"))
 		destination        = c.String(directoryOption)
-		pazuzufileFeatures []string
+		pazuzufileFeatures []pazuzu.PazuzuFileFeature
+		image              *pazuzu.ImageConfig
+		build              *pazuzu.BuildOptions
 		baseImage          = c.String("base")
+		frozen             = c.Bool("frozen")
+		update             = c.Bool("update")
+		optimize           = c.Bool("optimize")
+		platform           = c.String("base-platform")
 	)
 
-	if (c.String("add") == "") && (c.String("init") == "") {
-		cli.ShowCommandHelp(c, "compose")
-		return errors.New("ERROR: No feature specified. Please use at least one of -a or -i for the compose command.")
+	if frozen && update {
+		return errors.New("ERROR: --frozen and --update can not be used together.")
 	}
 
 	err := checkDestination(destination)
@@ -30,19 +35,37 @@ var composeAction = func(c *cli.Context) error {
 	pazuzufilePath := getAbsoluteFilePath(destination, PazuzufileName)
 	dockerfilePath := getAbsoluteFilePath(destination, DockerfileName)
 	testSpecPath := getAbsoluteFilePath(destination, shared.TestSpecFilename)
+	lockPath := getAbsoluteFilePath(destination, LockfileName)
 
 	pazuzuFile, success := readPazuzuFile(pazuzufilePath)
+	// without -a or -i the features of an existing Pazuzufile are composed again
+	if (c.String("add") == "") && (c.String("init") == "") && !success {
+		cli.ShowCommandHelp(c, "compose")
+		return errors.New("ERROR: No feature specified. Please use at least one of -a or -i for the compose command.")
+	}
 	if success {
 		pazuzufileFeatures = pazuzuFile.Features
+		image = pazuzuFile.Image
+		build = pazuzuFile.Build
+		optimize = optimize || pazuzuFile.Optimize
 		if baseImage == "" {
 			baseImage = pazuzuFile.Base
+			// the platform belongs to the base image
+			if platform == "" {
+				platform = pazuzuFile.BasePlatform
+			}
 		}
 	}
 
-	featureNames, err := generateFeaturesList(pazuzufileFeatures, initFeatures, addFeatures)
+	featureNames, err := generateFeaturesList(pazuzu.PazuzuFile{Features: pazuzufileFeatures}.FeatureNames(), initFeatures, addFeatures)
 	if err != nil {
 		return err
 	}
+	previousFeatures := pazuzufileFeatures
+	// parameters and custom snippets are kept for the features which are still there, unless starting from scratch
+	if len(initFeatures) > 0 {
+		pazuzufileFeatures = nil
+	}
 	fmt.Printf("Resolving the following features: %s\n", featureNames)
 
 	config := pazuzu.GetConfig()
@@ -51,44 +74,107 @@ var composeAction = func(c *cli.Context) error {
 		return err // TODO: process properly into human-readable message
 	}
 
-	features, err := checkFeaturesInRepository(featureNames, storageReader)
-	if err != nil {
-		return err
-	}
-
 	if baseImage == "" {
 		baseImage = config.Base
 	}
 
-	fmt.Printf("Generating %s...", pazuzufilePath)
-
 	pazuzuFile = &pazuzu.PazuzuFile{
-		Base:     baseImage,
-		Features: features,
+		Base:         baseImage,
+		Features:     withPreviousFeatures(featureNames, pazuzufileFeatures),
+		Image:        image,
+		Build:        build,
+		Optimize:     optimize,
+		BasePlatform: platform,
+	}
+
+	if _, ok := pazuzu.DetectPlatform(baseImage); !ok && platform == "" {
+		fmt.Printf("Could not detect the platform of %s, features are not checked for compatibility."+
+			" Use --base-platform to set it.\n", baseImage)
 	}
 
-	err = writePazuzuFile(pazuzufilePath, pazuzuFile)
+	custom, err := pazuzuFile.CustomFeatures(destination)
 	if err != nil {
 		return err
-	} else {
-		fmt.Println(" [DONE]")
 	}
 
-	fmt.Printf("Generating %s...", dockerfilePath)
+	// generate everything first, so existing files are kept untouched on failure
+	p := pazuzu.Pazuzu{
+		StorageReader: storageReader,
+		Params:        pazuzuFile.FeatureParams(),
+		Custom:        custom,
+		Optimize:      optimize,
+		BasePlatform:  platform,
+		Version:       Version,
+	}
+	if image != nil {
+		p.Image = *image
+	}
+	err = p.Generate(pazuzuFile.Base, pazuzuFile.FeatureNames())
+	if err != nil {
+		return fmt.Errorf("Could not compose features, no files were written:\n%s", err)
+	}
 
-	p := pazuzu.Pazuzu{StorageReader: storageReader}
-	p.Generate(pazuzuFile.Base, pazuzuFile.Features)
+	lock, err := readLock(lockPath)
+	if err != nil {
+		return err
+	}
+	if err := checkLock(lock, p.Lock, frozen, update); err != nil {
+		return fmt.Errorf("Could not compose features, no files were written:\n%s", err)
+	}
+
+	fmt.Printf("Writing %s, %s, %s and %s...", pazuzufilePath, lockPath, dockerfilePath, testSpecPath)
+	pazuzufileContent, err := marshalPazuzuFile(pazuzufilePath, pazuzuFile)
+	if err != nil {
+		return err
+	}
+	lockContent, err := marshalLock(lockPath, p.Lock)
+	if err != nil {
+		return err
+	}
 
-	err = writeFile(dockerfilePath, p.Dockerfile)
+	files := []fileContent{
+		pazuzufileContent,
+		lockContent,
+		{path: dockerfilePath, contents: p.Dockerfile},
+		{path: testSpecPath, contents: p.TestSpec},
+	}
+	files = append(files, assetFiles(destination, p.Files)...)
 
-	fmt.Printf("Generating %s...", testSpecPath)
-	err = writeFile(testSpecPath, p.TestSpec)
+	// asset folders of the features composed now and before are replaced, so no stale files are left
+	locks := []pazuzu.Lock{p.Lock}
+	if lock != nil {
+		locks = append(locks, *lock)
+	}
+	dirs := assetDirs(destination, locks, append(previousFeatures, pazuzuFile.Features...))
 
+	err = writeFiles(dirs, files...)
 	if err != nil {
+		fmt.Println(" [FAILED]")
 		return err
-	} else {
-		fmt.Println(" [DONE]")
 	}
+	fmt.Println(" [DONE]")
 
 	return nil
 }
+
+// checkLock compares the features resolved now with the lock of the previous compose, if any.
+// Frozen mode fails on any difference, otherwise only changed content of locked features fails,
+// unless the lock is updated deliberately.
+func checkLock(lock *pazuzu.Lock, current pazuzu.Lock, frozen bool, update bool) error {
+	if lock == nil {
+		if frozen {
+			return fmt.Errorf("%s is required in frozen mode", LockfileName)
+		}
+		return nil
+	}
+
+	if frozen {
+		return lock.Verify(current)
+	}
+
+	changed, _ := lock.Compare(current)
+	if len(changed) > 0 && !update {
+		return fmt.Errorf("%s\nUse --update to accept the changes.", &pazuzu.LockMismatchError{Differences: changed})
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/zalando-incubator/pazuzu"
+)
+
+func TestCheckLock(t *testing.T) {
+	lock := &pazuzu.Lock{Features: []pazuzu.LockedFeature{{Name: "java", Hash: "sha256:1"}}}
+	added := pazuzu.Lock{Features: []pazuzu.LockedFeature{{Name: "java", Hash: "sha256:1"}, {Name: "node", Hash: "sha256:2"}}}
+	changed := pazuzu.Lock{Features: []pazuzu.LockedFeature{{Name: "java", Hash: "sha256:3"}}}
+
+	cases := []struct {
+		name    string
+		lock    *pazuzu.Lock
+		current pazuzu.Lock
+		frozen  bool
+		update  bool
+		fails   bool
+	}{
+		{"Missing lock is created", nil, added, false, false, false},
+		{"Missing lock fails in frozen mode", nil, added, true, false, true},
+		{"Added features are locked", lock, added, false, false, false},
+		{"Added features fail in frozen mode", lock, added, true, false, true},
+		{"Changed content fails", lock, changed, false, false, true},
+		{"Changed content is updated deliberately", lock, changed, false, true, false},
+		{"Same content passes in frozen mode", lock, *lock, true, false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := checkLock(c.lock, c.current, c.frozen, c.update)
+			if (err != nil) != c.fails {
+				t.Errorf("unexpected result: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+	"github.com/zalando-incubator/pazuzu"
+	"github.com/zalando-incubator/pazuzu/shared"
+	"github.com/zalando-incubator/pazuzu/storageconnector"
+)
+
+func getStorageWriter() (storageconnector.StorageWriter, error) {
+	storageWriter, err := pazuzu.GetStorageWriter(*pazuzu.GetConfig())
+	if err != nil {
+		return nil, fmt.Errorf("Error during storage setup: %s", err)
+	}
+	return storageWriter, nil
+}
+
+// readFeatureArg reads the feature folder given as the only argument.
+func readFeatureArg(c *cli.Context) (shared.Feature, error) {
+	if len(c.Args()) != 1 {
+		return shared.Feature{}, pazuzu.ErrTooFewOrManyParameters
+	}
+
+	feature, err := storageconnector.ReadFeatureFolder(c.Args().Get(0))
+	if err != nil {
+		return shared.Feature{}, fmt.Errorf("Could not read feature folder %s: %s", c.Args().Get(0), err)
+	}
+	return feature, nil
+}
+
+func publishFeature(c *cli.Context) error {
+	feature, err := readFeatureArg(c)
+	if err != nil {
+		return err
+	}
+
+	storageWriter, err := getStorageWriter()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Publishing %s...", feature.Meta.Name)
+	if err := storageWriter.CreateFeature(feature); err != nil {
+		fmt.Println(" [FAILED]")
+		return err
+	}
+	fmt.Println(" [DONE]")
+	return nil
+}
+
+func updateFeature(c *cli.Context) error {
+	feature, err := readFeatureArg(c)
+	if err != nil {
+		return err
+	}
+
+	storageWriter, err := getStorageWriter()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Updating %s...", feature.Meta.Name)
+	if err := storageWriter.UpdateFeature(feature); err != nil {
+		fmt.Println(" [FAILED]")
+		return err
+	}
+	fmt.Println(" [DONE]")
+	return nil
+}
+
+func deleteFeature(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		return pazuzu.ErrTooFewOrManyParameters
+	}
+	name := c.Args().Get(0)
+
+	storageWriter, err := getStorageWriter()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Deleting %s...", name)
+	if err := storageWriter.DeleteFeature(name); err != nil {
+		fmt.Println(" [FAILED]")
+		return err
+	}
+	fmt.Println(" [DONE]")
+	return nil
+}
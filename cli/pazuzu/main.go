@@ -27,7 +27,11 @@ func main() {
 		searchCmd,
 		composeCmd,
 		buildCmd,
+		pushCmd,
 		configCmd,
+		featureCmd,
+		serveCmd,
+		inspectCmd,
 	}
 
 	// global flags
@@ -36,6 +40,18 @@ func main() {
 			Name:  "verbose, v",
 			Usage: "Verbose output",
 		},
+		cli.StringFlag{
+			Name:  "docker-endpoint, e",
+			Usage: "Docker `ENDPOINT`, instead of DOCKER_HOST or docker.endpoint of the configuration",
+		},
+		cli.StringFlag{
+			Name:  "builder",
+			Usage: "Builds, tests and pushes images with `BUILDER`: api, docker, podman or buildah, instead of docker.builder of the configuration",
+		},
+		cli.BoolFlag{
+			Name:  "offline",
+			Usage: "Use cached features only, never ask the registry",
+		},
 	}
 	app.Before = func(c *cli.Context) error {
 		// remove formatting for log module
@@ -53,6 +69,9 @@ func main() {
 			fmt.Println(errCnf)
 			os.Exit(1)
 		}
+		if c.Bool("offline") {
+			pazuzu.GetConfig().Cache.SetOffline(true)
+		}
 
 		return nil
 	}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/urfave/cli"
+	"github.com/zalando-incubator/pazuzu"
+	"github.com/zalando-incubator/pazuzu/registryserver"
+	"github.com/zalando-incubator/pazuzu/storageconnector"
+)
+
+// Serves features of a local feature directory with the API of pazuzu-registry.
+func serveFeatures(c *cli.Context) error {
+	root := c.String("root")
+	if root == "" {
+		root = pazuzu.GetConfig().Local.Root
+	}
+
+	storage, err := storageconnector.NewLocalStorage(root)
+	if err != nil {
+		return fmt.Errorf("Error during storage setup: %s", err)
+	}
+
+	server := registryserver.NewServer(storage, storage)
+	if c.Bool("read-only") {
+		server.Writer = nil
+	}
+
+	listen := c.String("listen")
+	fmt.Printf("Serving features of %s on %s%s\n", root, listen, registryserver.BasePath)
+	return http.ListenAndServe(listen, server)
+}
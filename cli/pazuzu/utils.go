@@ -2,14 +2,17 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
-	"log"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/zalando-incubator/pazuzu"
+	"github.com/zalando-incubator/pazuzu/shared"
 	"github.com/zalando-incubator/pazuzu/storageconnector"
 )
 
@@ -48,6 +51,46 @@ func generateFeaturesList(pazuzufileFeatures []string, featuresToInit []string,
 	return features, nil
 }
 
+// withPreviousFeatures creates Pazuzufile features of the given names, parameters and custom
+// snippets are taken over from previous features of the same name.
+func withPreviousFeatures(names []string, previous []pazuzu.PazuzuFileFeature) []pazuzu.PazuzuFileFeature {
+	byName := map[string]pazuzu.PazuzuFileFeature{}
+	for _, feature := range previous {
+		byName[feature.Name] = feature
+	}
+
+	features := make([]pazuzu.PazuzuFileFeature, 0, len(names))
+	for _, name := range names {
+		feature, ok := byName[name]
+		if !ok {
+			feature = pazuzu.PazuzuFileFeature{Name: name}
+		}
+		features = append(features, feature)
+	}
+	return features
+}
+
+// filterByBase keeps the features supporting the platform of a base image, given as image name or
+// as family[:version].
+func filterByBase(features []shared.FeatureMeta, base string) ([]shared.FeatureMeta, error) {
+	platform, ok := pazuzu.DetectPlatform(base)
+	if !ok {
+		return nil, fmt.Errorf("could not detect the platform of %s, use family[:version] such as ubuntu:16.04", base)
+	}
+
+	var result []shared.FeatureMeta
+	for _, meta := range features {
+		supported, err := pazuzu.SupportsPlatform(meta, platform)
+		if err != nil {
+			return nil, err
+		}
+		if supported {
+			result = append(result, meta)
+		}
+	}
+	return result, nil
+}
+
 func appendIfMissing(slice []string, element string) []string {
 	for _, next := range slice {
 		if next == element {
@@ -75,49 +118,265 @@ func readPazuzuFile(path string) (*pazuzu.PazuzuFile, bool) {
 	return &pazuzuFile, true
 }
 
-func writePazuzuFile(path string, pazuzuFile *pazuzu.PazuzuFile) error {
-	// TODO: do it safer way (#108)
-	file, err := os.Create(path)
-	if err != nil {
-		return errors.New(fmt.Sprintf("Could not create %v", PazuzufileName))
+// rename is replaced in tests to simulate failures.
+var rename = os.Rename
+
+// fileContent is a file to be written by writeFiles.
+type fileContent struct {
+	path     string
+	contents []byte
+	mode     os.FileMode // permissions of an existing file are kept if zero
+}
+
+// assetFiles returns asset files of the features to be written to the build context directory,
+// each feature has its own folder there.
+func assetFiles(destination string, files map[string]shared.FeatureFile) []fileContent {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
 	}
-	defer file.Close()
+	sort.Strings(paths)
 
-	writer := bufio.NewWriter(file)
-	pazuzu.Write(writer, *pazuzuFile)
+	result := []fileContent{}
+	for _, path := range paths {
+		mode := os.FileMode(0644)
+		if files[path].Executable {
+			mode = 0755
+		}
+		result = append(result, fileContent{
+			path:     getAbsoluteFilePath(destination, filepath.FromSlash(path)),
+			contents: files[path].Content,
+			mode:     mode,
+		})
+	}
+	return result
+}
 
-	writer.Flush()
-	return nil
+// assetDirs returns the asset folders of the features of the locks, to be replaced as a whole by
+// compose. Folders of custom features hold the files of their snippets and are kept.
+func assetDirs(destination string, locks []pazuzu.Lock, custom []pazuzu.PazuzuFileFeature) []string {
+	kept := map[string]bool{}
+	for _, feature := range custom {
+		if feature.IsCustom() {
+			kept[feature.Name] = true
+		}
+	}
+
+	var dirs []string
+	for _, lock := range locks {
+		for _, feature := range lock.Features {
+			if kept[feature.Name] || !storageconnector.ValidFeatureName(feature.Name) {
+				continue
+			}
+			kept[feature.Name] = true
+			dirs = append(dirs, getAbsoluteFilePath(destination, feature.Name))
+		}
+	}
+	return dirs
 }
 
-func writeFile(path string, contents []byte) error {
-	file, err := os.Create(path)
+// Reads Pazuzufile.lock, returns nil if there is none.
+func readLock(path string) (*pazuzu.Lock, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return errors.New(fmt.Sprintf("Could not create %v", path))
+		return nil, err
 	}
 	defer file.Close()
 
-	writer := bufio.NewWriter(file)
-	writer.Write(contents)
-	writer.Flush()
+	lock, err := pazuzu.ReadLock(file)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %v: %s", LockfileName, err)
+	}
+	return &lock, nil
+}
 
-	return nil
+func marshalLock(path string, lock pazuzu.Lock) (fileContent, error) {
+	var buf bytes.Buffer
+	if err := pazuzu.WriteLock(&buf, lock); err != nil {
+		return fileContent{}, fmt.Errorf("Could not create %v: %s", LockfileName, err)
+	}
+	return fileContent{path: path, contents: buf.Bytes()}, nil
 }
 
-func checkFeaturesInRepository(names []string, storage storageconnector.StorageReader) ([]string, error) {
-	var features []string
+func marshalPazuzuFile(path string, pazuzuFile *pazuzu.PazuzuFile) (fileContent, error) {
+	var buf bytes.Buffer
+	if err := pazuzu.Write(&buf, *pazuzuFile); err != nil {
+		return fileContent{}, fmt.Errorf("Could not create %v: %s", PazuzufileName, err)
+	}
+	return fileContent{path: path, contents: buf.Bytes()}, nil
+}
 
-	for _, name := range names {
-		log.Printf("Checking: %v\n", name)
+// writeFiles replaces all the given files as one unit, along with the given directories as a
+// whole, so files no longer written there are removed. Every file is written to a temporary file
+// next to it first and all of them are renamed into place only once they were written completely.
+// If anything fails, the previous set of files and directories is restored.
+func writeFiles(dirs []string, files ...fileContent) (err error) {
+	var (
+		temps      []string
+		created    []string // directories created for the files, parents first
+		dirBackups []string
+	)
+	defer func() {
+		for _, temp := range temps {
+			os.Remove(temp)
+		}
+		if err == nil {
+			for _, backup := range dirBackups {
+				os.RemoveAll(backup)
+			}
+			return
+		}
+		for i := len(created) - 1; i >= 0; i-- {
+			os.Remove(created[i])
+		}
+		for i, backup := range dirBackups {
+			if backup != "" {
+				rename(backup, dirs[i])
+			}
+		}
+	}()
 
-		_, err := storage.GetMeta(name)
+	for _, dir := range dirs {
+		backup, err := backupFile(dir)
 		if err != nil {
-			return features, errors.New(fmt.Sprintf("Feature %v not found", name))
+			return err
 		}
-		features = append(features, fmt.Sprintf("%v", name))
+		dirBackups = append(dirBackups, backup)
 	}
 
-	return features, nil
+	for _, file := range files {
+		temp, newDirs, err := writeTempFile(file)
+		created = append(created, newDirs...)
+		if err != nil {
+			return err
+		}
+		temps = append(temps, temp)
+	}
+
+	var backups []string
+	var replaced int
+	rollback := func() {
+		for i := replaced - 1; i >= 0; i-- {
+			if backups[i] != "" {
+				rename(backups[i], files[i].path)
+			} else {
+				os.Remove(files[i].path)
+			}
+		}
+		for i := replaced; i < len(backups); i++ {
+			if backups[i] != "" {
+				rename(backups[i], files[i].path)
+			}
+		}
+	}
+
+	for i, file := range files {
+		backup, err := backupFile(file.path)
+		if err != nil {
+			rollback()
+			return err
+		}
+		backups = append(backups, backup)
+
+		if err := rename(temps[i], file.path); err != nil {
+			rollback()
+			return fmt.Errorf("Could not write %v: %s", file.path, err)
+		}
+		replaced++
+	}
+
+	for _, backup := range backups {
+		if backup != "" {
+			os.Remove(backup)
+		}
+	}
+	return nil
+}
+
+// writeTempFile writes the file contents to a new temporary file in the same directory
+// and returns its name. Missing directories are created and returned, parents first.
+func writeTempFile(file fileContent) (string, []string, error) {
+	dir, name := filepath.Split(file.path)
+	if dir == "" {
+		dir = "."
+	}
+	created, err := mkdirAll(filepath.Clean(dir))
+	if err != nil {
+		return "", created, fmt.Errorf("Could not create %v: %s", dir, err)
+	}
+
+	temp, err := ioutil.TempFile(dir, "."+name+".")
+	if err != nil {
+		return "", created, fmt.Errorf("Could not create %v: %s", file.path, err)
+	}
+
+	_, err = temp.Write(file.contents)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		mode := file.mode
+		if mode == 0 {
+			mode = fileMode(file.path)
+		}
+		err = os.Chmod(temp.Name(), mode)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return "", created, fmt.Errorf("Could not write %v: %s", file.path, err)
+	}
+
+	return temp.Name(), created, nil
+}
+
+// mkdirAll creates a directory along with its missing parents, like os.MkdirAll, and returns
+// the directories it created, parents first.
+func mkdirAll(dir string) ([]string, error) {
+	var missing []string
+	for path := dir; ; path = filepath.Dir(path) {
+		if _, err := os.Lstat(path); err == nil || path == filepath.Dir(path) {
+			break
+		}
+		missing = append([]string{path}, missing...)
+	}
+
+	var created []string
+	for _, path := range missing {
+		if err := os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+			return created, err
+		}
+		created = append(created, path)
+	}
+	return created, nil
+}
+
+// fileMode returns the permissions of an existing file, to be kept by its replacement.
+func fileMode(path string) os.FileMode {
+	if info, err := os.Stat(path); err == nil {
+		return info.Mode().Perm()
+	}
+	return 0644
+}
+
+// backupFile moves an existing file out of the way and returns its new name,
+// or an empty name if there is no such file.
+func backupFile(path string) (string, error) {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return "", nil
+	}
+
+	dir, name := filepath.Split(path)
+	backup := filepath.Join(dir, "."+name+".bak")
+	if err := rename(path, backup); err != nil {
+		return "", fmt.Errorf("Could not replace %v: %s", path, err)
+	}
+	return backup, nil
 }
 
 func checkDestination(destination string) error {
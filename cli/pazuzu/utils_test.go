@@ -1,9 +1,15 @@
 package main
 
 import (
-	"github.com/zalando-incubator/pazuzu"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+
+	"github.com/zalando-incubator/pazuzu"
+	"github.com/zalando-incubator/pazuzu/shared"
 )
 
 const checkMark = "\u2713"
@@ -122,3 +128,185 @@ func TestGetFeaturesList(t *testing.T) {
 		}
 	})
 }
+
+func setupFiles(t *testing.T, contents string, names ...string) (string, []fileContent) {
+	dir, err := ioutil.TempDir("", "pazuzu_write_files")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var files []fileContent
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, fileContent{path: path, contents: []byte(contents)})
+	}
+	return dir, files
+}
+
+func checkFiles(t *testing.T, dir string, expected string, names ...string) {
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(data) != expected {
+			t.Errorf("%s should contain '%s', got '%s' (%v)", name, expected, data, err)
+		}
+	}
+
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != len(names) {
+		t.Errorf("Temporary files should be removed, found %d files", len(entries))
+	}
+}
+
+// checkFolders checks the folders of dir, which are left once files were written.
+func checkFolders(t *testing.T, dir string, expected ...string) {
+	entries, _ := ioutil.ReadDir(dir)
+	folders := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			folders = append(folders, entry.Name())
+		}
+	}
+	if !reflect.DeepEqual(folders, expected) {
+		t.Errorf("Folders should be %v, found %v", expected, folders)
+	}
+}
+
+func TestWriteFiles(t *testing.T) {
+	names := []string{PazuzufileName, DockerfileName, "test.bats"}
+
+	t.Run("Replaces all files", func(t *testing.T) {
+		dir, files := setupFiles(t, "new", names...)
+		defer os.RemoveAll(dir)
+
+		if err := writeFiles(nil, files...); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		checkFiles(t, dir, "new", names...)
+	})
+
+	t.Run("Keeps old files when writing fails", func(t *testing.T) {
+		dir, files := setupFiles(t, "new", names...)
+		defer os.RemoveAll(dir)
+
+		// a file can not be written below another file
+		files = append(files, fileContent{path: filepath.Join(dir, PazuzufileName, "file"), contents: []byte("new")})
+		if err := writeFiles(nil, files...); err == nil {
+			t.Fatal("should fail")
+		}
+		checkFiles(t, dir, "old", names...)
+	})
+
+	t.Run("Creates directories of asset files", func(t *testing.T) {
+		dir, files := setupFiles(t, "new", names...)
+		defer os.RemoveAll(dir)
+
+		files = append(files, assetFiles(dir, map[string]shared.FeatureFile{
+			"java/bin/install.sh": {Content: []byte("new"), Executable: true},
+		})...)
+		if err := writeFiles(nil, files...); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		path := filepath.Join(dir, "java", "bin", "install.sh")
+		if data, err := ioutil.ReadFile(path); err != nil || string(data) != "new" {
+			t.Errorf("Asset file should be written: '%s' (%v)", data, err)
+		}
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0755 {
+			t.Errorf("Asset file should be executable: %v, %v", info, err)
+		}
+	})
+
+	t.Run("Replaces asset folders", func(t *testing.T) {
+		dir, files := setupFiles(t, "new", names...)
+		defer os.RemoveAll(dir)
+		for _, path := range []string{"java/old.sh", "python/old.sh", "custom/snippet.sh"} {
+			os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755)
+			ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(path)), []byte("old"), 0644)
+		}
+
+		files = append(files, assetFiles(dir, map[string]shared.FeatureFile{"java/new.sh": {Content: []byte("new")}})...)
+		locks := []pazuzu.Lock{{Features: []pazuzu.LockedFeature{{Name: "java"}, {Name: "python"}, {Name: "custom"}}}}
+		dirs := assetDirs(dir, locks, []pazuzu.PazuzuFileFeature{{Name: "custom", Snippet: "COPY custom/ /"}})
+		if err := writeFiles(dirs, files...); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		checkFiles(t, filepath.Join(dir, "java"), "new", "new.sh")
+		checkFolders(t, dir, "custom", "java")
+	})
+
+	t.Run("Restores asset folders when writing fails", func(t *testing.T) {
+		dir, files := setupFiles(t, "new", names...)
+		defer os.RemoveAll(dir)
+		os.Mkdir(filepath.Join(dir, "java"), 0755)
+		ioutil.WriteFile(filepath.Join(dir, "java", "old.sh"), []byte("old"), 0644)
+
+		files = append(files, assetFiles(dir, map[string]shared.FeatureFile{
+			"java/new.sh":        {Content: []byte("new")},
+			"python/bin/new.sh":  {Content: []byte("new")},
+			"test.bats/file.txt": {Content: []byte("new")},
+		})...)
+		dirs := []string{filepath.Join(dir, "java"), filepath.Join(dir, "python")}
+		if err := writeFiles(dirs, files...); err == nil {
+			t.Fatal("should fail")
+		}
+		checkFiles(t, filepath.Join(dir, "java"), "old", "old.sh")
+		checkFolders(t, dir, "java")
+	})
+
+	t.Run("Restores old files when renaming fails", func(t *testing.T) {
+		dir, files := setupFiles(t, "new", names...)
+		defer os.RemoveAll(dir)
+
+		renames := 0
+		rename = func(from, to string) error {
+			renames++
+			// fail to move the last new file into place
+			if renames == 2*len(files) {
+				return errors.New("disk failure")
+			}
+			return os.Rename(from, to)
+		}
+		defer func() { rename = os.Rename }()
+
+		if err := writeFiles(nil, files...); err == nil {
+			t.Fatal("should fail")
+		}
+		checkFiles(t, dir, "old", names...)
+	})
+}
+
+func TestFilterByBase(t *testing.T) {
+	features := []shared.FeatureMeta{
+		{Name: "curl"},
+		{Name: "java", Platforms: []string{"ubuntu:16.04"}},
+		{Name: "git", Platforms: []string{"alpine"}},
+	}
+
+	t.Run("Keeps features supporting the base", func(t *testing.T) {
+		for base, expected := range map[string][]string{
+			"ubuntu:xenial": {"curl", "java"},
+			"alpine:3.6":    {"curl", "git"},
+			"debian":        {"curl"},
+		} {
+			result, err := filterByBase(features, base)
+			if err != nil {
+				t.Fatalf("should not fail: %s", err)
+			}
+			var names []string
+			for _, meta := range result {
+				names = append(names, meta.Name)
+			}
+			if !reflect.DeepEqual(names, expected) {
+				t.Errorf("wrong features for %s: %v", base, names)
+			}
+		}
+	})
+
+	t.Run("Fails for unknown platforms", func(t *testing.T) {
+		if _, err := filterByBase(features, "example.org/base"); err == nil {
+			t.Error("should fail")
+		}
+	})
+}
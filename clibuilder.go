@@ -0,0 +1,219 @@
+package pazuzu
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// longest line of tool output read, the default of bufio is 64 KiB
+const maxOutputLine = 16 * 1024 * 1024
+
+// digest printed by docker push
+var pushDigestRegexp = regexp.MustCompile(`digest: (sha256:[0-9a-f]+)`)
+
+// cliBuilder builds images with the command line tool of a container engine: docker, podman or
+// buildah, found in PATH. The tools use their own credentials for pushing.
+type cliBuilder struct {
+	tool   string
+	handle func(BuildEvent)
+}
+
+func (b *cliBuilder) Build(request BuildRequest) error {
+	contextDir := request.ContextDir
+	if contextDir == "" {
+		dir, err := ioutil.TempDir("", "pazuzu-build")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		if err := ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), request.Dockerfile, 0644); err != nil {
+			return err
+		}
+		contextDir = dir
+	}
+	dockerfileName := request.DockerfileName
+	if dockerfileName == "" {
+		dockerfileName = "Dockerfile"
+	}
+
+	args := []string{"build"}
+	switch b.tool {
+	case BuilderBuildah:
+		args = []string{"bud"}
+	case BuilderDocker:
+		args = append(args, "--progress=plain")
+	}
+	args = append(args, "-t", request.Name, "-f", filepath.Join(contextDir, dockerfileName))
+	for _, arg := range request.Options.buildArgs() {
+		args = append(args, "--build-arg", arg.Name+"="+arg.Value)
+	}
+	if request.Options.NoCache {
+		args = append(args, "--no-cache")
+	}
+	if request.Options.Pull {
+		args = append(args, "--pull")
+	}
+	args = append(args, contextDir)
+
+	cmd := exec.Command(b.tool, args...)
+	if b.tool == BuilderDocker {
+		cmd.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
+	}
+	progress := newBuildProgress(buildInstructions(request.Dockerfile), b.handle)
+	last, err := b.run(cmd, progress.line)
+	if err != nil && progress.err == nil {
+		progress.fail(failureMessage(last, err))
+	}
+	if progress.err != nil {
+		return progress.err
+	}
+	return nil
+}
+
+func (b *cliBuilder) Tag(image string, name string) error {
+	last, err := b.run(exec.Command(b.tool, "tag", image, name), b.output)
+	if err != nil {
+		return fmt.Errorf("%s tag failed: %s", b.tool, failureMessage(last, err))
+	}
+	return nil
+}
+
+// Run runs the command in a new container, which buildah needs to create beforehand. The command
+// runs as root with bash, whatever user and entrypoint the image is configured with.
+func (b *cliBuilder) Run(image string, command string) error {
+	volume := tempDir + ":" + mountPoint
+	if b.tool != BuilderBuildah {
+		last, err := b.run(exec.Command(b.tool, "run", "--rm", "-v", volume, "--entrypoint", "/bin/bash", "--user", "root",
+			image, "-c", command), b.output)
+		if err != nil {
+			return fmt.Errorf("%s run failed: %s", b.tool, failureMessage(last, err))
+		}
+		return nil
+	}
+
+	output, err := exec.Command(b.tool, "from", image).Output()
+	if err != nil {
+		return fmt.Errorf("buildah from failed: %s", failureMessage("", err))
+	}
+	container := strings.TrimSpace(string(output))
+	defer exec.Command(b.tool, "rm", container).Run()
+
+	last, err := b.run(exec.Command(b.tool, "run", "-v", volume, "--user", "root", container, "--", "/bin/bash", "-c", command), b.output)
+	if err != nil {
+		return fmt.Errorf("buildah run failed: %s", failureMessage(last, err))
+	}
+	return nil
+}
+
+// Push reads the digest from the output of docker, podman and buildah write it to a file.
+func (b *cliBuilder) Push(name string) (string, error) {
+	var digest, digestFile string
+	args := []string{"push", name}
+	if b.tool != BuilderDocker {
+		file, err := ioutil.TempFile("", "pazuzu-digest")
+		if err != nil {
+			return "", err
+		}
+		file.Close()
+		defer os.Remove(file.Name())
+		digestFile = file.Name()
+		args = []string{"push", "--digestfile", digestFile, name}
+	}
+
+	last, err := b.run(exec.Command(b.tool, args...), func(line string) {
+		if match := pushDigestRegexp.FindStringSubmatch(line); match != nil {
+			digest = match[1]
+		}
+		b.output(line)
+	})
+	if err != nil {
+		return "", &PushError{Image: name, Message: failureMessage(last, err)}
+	}
+	if digestFile != "" {
+		if content, err := ioutil.ReadFile(digestFile); err == nil {
+			digest = strings.TrimSpace(string(content))
+		}
+	}
+	if digest == "" {
+		return "", &PushError{Image: name, Message: "no digest in the output of " + b.tool}
+	}
+	return digest, nil
+}
+
+func (b *cliBuilder) Labels(image string) (map[string]string, error) {
+	args := []string{"image", "inspect", "--format", "{{json .Config.Labels}}", image}
+	if b.tool == BuilderBuildah {
+		args = []string{"inspect", "--type", "image", "--format", "{{json .OCIv1.Config.Labels}}", image}
+	}
+	output, err := exec.Command(b.tool, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s inspect failed: %s", b.tool, failureMessage("", err))
+	}
+
+	var labels map[string]string
+	if err := json.Unmarshal(output, &labels); err != nil {
+		return nil, fmt.Errorf("invalid labels from %s: %s", b.tool, err)
+	}
+	return labels, nil
+}
+
+func (b *cliBuilder) output(line string) {
+	b.handle(BuildEvent{Type: BuildOutput, Message: line})
+}
+
+// run runs the tool, passing every line of its output to handle. It returns the last line, which
+// names the cause if the tool fails. If the output can not be read, the read error is returned as
+// both the last line and the error.
+func (b *cliBuilder) run(cmd *exec.Cmd, handle func(string)) (string, error) {
+	reader, writer := io.Pipe()
+	cmd.Stdout, cmd.Stderr = writer, writer
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("could not run %s: %s", b.tool, err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		writer.Close()
+		done <- err
+	}()
+
+	var last string
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxOutputLine)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) != "" {
+			last = line
+		}
+		handle(line)
+	}
+	drain(reader)
+	err := <-done
+
+	if scanErr := scanner.Err(); scanErr != nil {
+		message := fmt.Sprintf("could not read the output of %s: %s", b.tool, scanErr)
+		return message, errors.New(message)
+	}
+	return last, err
+}
+
+// failureMessage describes the failure of a tool by its last line of output, or its stderr.
+func failureMessage(last string, err error) string {
+	if exitErr, ok := err.(*exec.ExitError); ok && last == "" {
+		last = strings.TrimSpace(string(exitErr.Stderr))
+	}
+	if last == "" {
+		return err.Error()
+	}
+	return last
+}
@@ -11,8 +11,10 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cevaris/ordered_map"
+	"github.com/fsouza/go-dockerclient"
 	"github.com/jinzhu/copier"
 	"gopkg.in/yaml.v2"
 
@@ -33,22 +35,104 @@ const (
 	DefaultRegistryPort = 8080
 	// Default scheme for the registry
 	DefaultRegistryScheme = "http"
+	// Environment variable with the OAuth2 token for the registry, overrides configured tokens
+	RegistryTokenEnvVar = "PAZUZU_TOKEN"
+
+	// StorageTypeLocal: directory with a folder per feature
+	StorageTypeLocal = "local"
+	// Default root directory for the local storage, relative to the user home
+	DefaultLocalRootPart = ".pazuzu-features"
+
+	// StorageTypeGit: folder per feature in a git repository at a pinned ref
+	StorageTypeGit = "git"
+	// Default ref for the git storage
+	DefaultGitRef = "master"
+
+	// StorageTypeLayered: storages of the storages list, the first one having a feature wins
+	StorageTypeLayered = "layered"
+
+	// Default root directory for the cache of the registry storage, relative to the user home
+	DefaultCacheRootPart = ".cache/pazuzu"
+	// Default time to use cached features without asking the registry
+	DefaultCacheTTL = "5m"
+
+	// Default endpoint of the Docker daemon
+	DefaultDockerEndpoint = "unix:///var/run/docker.sock"
+	// Default directory with the TLS certificates for the Docker daemon, relative to the user home
+	DefaultDockerCertPathPart = ".docker"
+	// Environment variables of the Docker client, they override the docker configs
+	DockerHostEnvVar      = "DOCKER_HOST"
+	DockerTLSVerifyEnvVar = "DOCKER_TLS_VERIFY"
+	DockerCertPathEnvVar  = "DOCKER_CERT_PATH"
 )
 
 var config Config
 
 // registryConfig : config structure for Registry-storage
 type RegistryConfig struct {
-	Hostname string `yaml:"hostname" setter:"SetHostname" help:"Hostname String"`
-	Port     int    `yaml:"port" setter:"SetPort" help:"Port Integer"`
-	Scheme   string `yaml:"scheme" setter:"SetScheme" help:"Scheme String"`
+	Hostname  string `yaml:"hostname" setter:"SetHostname" help:"Hostname String"`
+	Port      int    `yaml:"port" setter:"SetPort" help:"Port Integer"`
+	Scheme    string `yaml:"scheme" setter:"SetScheme" help:"Scheme String"`
+	Token     string `yaml:"token" setter:"SetToken" help:"OAuth2 bearer token"`
+	TokenFile string `yaml:"token_file" setter:"SetTokenFile" help:"File with OAuth2 bearer token, re-read on every request"`
+}
+
+// LocalConfig : config structure for Local-storage
+type LocalConfig struct {
+	Root string `yaml:"root" setter:"SetRoot" help:"Directory containing a folder per feature"`
+}
+
+// GitConfig : config structure for Git-storage
+type GitConfig struct {
+	Path string `yaml:"path" setter:"SetPath" help:"Path to a working copy or a bare git repository"`
+	Ref  string `yaml:"ref" setter:"SetRef" help:"Branch, tag or commit hash to read features from"`
+}
+
+// CacheConfig : config structure for the cache of the registry storage
+type CacheConfig struct {
+	Root    string `yaml:"root" setter:"SetRoot" help:"Directory keeping features read from the registry"`
+	TTL     string `yaml:"ttl" setter:"SetTTL" help:"Time to use cached features without asking the registry (ex: '5m', '0s')"`
+	Offline bool   `yaml:"offline" setter:"SetOffline" help:"Use cached features only, never ask the registry"`
+}
+
+// DockerConfig : config structure for the Docker daemon
+type DockerConfig struct {
+	Endpoint  string `yaml:"endpoint" setter:"SetEndpoint" help:"Docker endpoint (ex: 'unix:///var/run/docker.sock', 'tcp://host:2376')"`
+	TLSVerify bool   `yaml:"tls_verify" setter:"SetTLSVerify" help:"Use TLS with client certificates and verify the daemon"`
+	CertPath  string `yaml:"cert_path" setter:"SetCertPath" help:"Directory with ca.pem, cert.pem and key.pem (default: ~/.docker)"`
+	Builder   string `yaml:"builder" setter:"SetBuilder" help:"Builder of the images: 'api' (Docker Engine API), 'docker' (CLI with BuildKit), 'podman' or 'buildah'"`
+}
+
+// StorageConfig : config structure for a storage of Layered-storage
+type StorageConfig struct {
+	Name     string         `yaml:"name"`
+	Type     string         `yaml:"type"`
+	Registry RegistryConfig `yaml:"registry,omitempty"`
+	Local    LocalConfig    `yaml:"local,omitempty"`
+	Git      GitConfig      `yaml:"git,omitempty"`
+}
+
+// StorageConfigs : storages of Layered-storage, in order of priority.
+type StorageConfigs []StorageConfig
+
+func (s StorageConfigs) String() string {
+	names := []string{}
+	for _, storage := range s {
+		names = append(names, fmt.Sprintf("%s(%s)", storage.Name, storage.Type))
+	}
+	return strings.Join(names, ", ")
 }
 
 // Config : actual config data structure.
 type Config struct {
 	Base        string         `yaml:"base" setter:"SetBase" help:"Base image name and tag (ex: 'ubuntu:14.04')"`
-	StorageType string         `yaml:"storage" setter:"SetStorageType" help:"Storage-type(registry) "`
+	StorageType string         `yaml:"storage" setter:"SetStorageType" help:"Storage-type(registry, local, git, layered) "`
+	Storages    StorageConfigs `yaml:"storages" help:"Storages of layered storage, in order of priority (edit config file to change)"`
 	Registry    RegistryConfig `yaml:"registry" help:"Pazuzu-registry configs"`
+	Local       LocalConfig    `yaml:"local" help:"Local-storage configs"`
+	Git         GitConfig      `yaml:"git" help:"Git-storage configs"`
+	Cache       CacheConfig    `yaml:"cache" help:"Cache configs"`
+	Docker      DockerConfig   `yaml:"docker" help:"Docker daemon configs"`
 }
 
 // SetBase : Setter of "Base".
@@ -76,12 +160,138 @@ func (r *RegistryConfig) SetScheme(scheme string) {
 	r.Scheme = scheme
 }
 
+// SetToken : Setter of RegistryConfig.Token.
+func (r *RegistryConfig) SetToken(token string) {
+	r.Token = token
+}
+
+// SetTokenFile : Setter of RegistryConfig.TokenFile.
+func (r *RegistryConfig) SetTokenFile(tokenFile string) {
+	r.TokenFile = tokenFile
+}
+
+// TokenSource : OAuth2 token from PAZUZU_TOKEN environment variable, token file or
+// configuration, in this order. Returns nil if no token is given.
+func (r *RegistryConfig) TokenSource() storageconnector.TokenSource {
+	if token := os.Getenv(RegistryTokenEnvVar); token != "" {
+		return storageconnector.StaticToken(token)
+	}
+	if r.TokenFile != "" {
+		return storageconnector.FileToken(r.TokenFile)
+	}
+	if r.Token != "" {
+		return storageconnector.StaticToken(r.Token)
+	}
+	return nil
+}
+
+// SetRoot : Setter of LocalConfig.Root.
+func (l *LocalConfig) SetRoot(root string) {
+	l.Root = root
+}
+
+// SetPath : Setter of GitConfig.Path.
+func (g *GitConfig) SetPath(path string) {
+	g.Path = path
+}
+
+// SetRef : Setter of GitConfig.Ref.
+func (g *GitConfig) SetRef(ref string) {
+	g.Ref = ref
+}
+
+// SetRoot : Setter of CacheConfig.Root.
+func (c *CacheConfig) SetRoot(root string) {
+	c.Root = root
+}
+
+// SetTTL : Setter of CacheConfig.TTL.
+func (c *CacheConfig) SetTTL(ttl string) {
+	c.TTL = ttl
+}
+
+// SetOffline : Setter of CacheConfig.Offline.
+func (c *CacheConfig) SetOffline(offline bool) {
+	c.Offline = offline
+}
+
+// SetEndpoint : Setter of DockerConfig.Endpoint.
+func (d *DockerConfig) SetEndpoint(endpoint string) {
+	d.Endpoint = endpoint
+}
+
+// SetTLSVerify : Setter of DockerConfig.TLSVerify.
+func (d *DockerConfig) SetTLSVerify(verify bool) {
+	d.TLSVerify = verify
+}
+
+// SetCertPath : Setter of DockerConfig.CertPath.
+func (d *DockerConfig) SetCertPath(certPath string) {
+	d.CertPath = certPath
+}
+
+// SetBuilder : Setter of DockerConfig.Builder.
+func (d *DockerConfig) SetBuilder(builder string) {
+	d.Builder = builder
+}
+
+// WithEnv : docker configs overridden by DOCKER_HOST, DOCKER_TLS_VERIFY and DOCKER_CERT_PATH
+// environment variables. As for the Docker client, any non-empty DOCKER_TLS_VERIFY enables TLS.
+func (d DockerConfig) WithEnv() DockerConfig {
+	if host := os.Getenv(DockerHostEnvVar); host != "" {
+		d.Endpoint = host
+	}
+	if os.Getenv(DockerTLSVerifyEnvVar) != "" {
+		d.TLSVerify = true
+	}
+	if certPath := os.Getenv(DockerCertPathEnvVar); certPath != "" {
+		d.CertPath = certPath
+	}
+	return d
+}
+
+// NewDockerClient : create client of the Docker daemon, using the client certificates of
+// CertPath if TLSVerify is set.
+func NewDockerClient(d DockerConfig) (*docker.Client, error) {
+	endpoint := d.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultDockerEndpoint
+	}
+	if !d.TLSVerify {
+		return docker.NewClient(endpoint)
+	}
+
+	certPath := d.CertPath
+	if certPath == "" {
+		certPath = filepath.Join(UserHomeDir(), DefaultDockerCertPathPart)
+	}
+	client, err := docker.NewTLSClient(endpoint,
+		filepath.Join(certPath, "cert.pem"),
+		filepath.Join(certPath, "key.pem"),
+		filepath.Join(certPath, "ca.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot set up TLS for %s with certificates of %s: %s", endpoint, certPath, err)
+	}
+	return client, nil
+}
+
 // InitDefaultConfig : Initialize config variable with defaults. (Does not loading configuration file)
 func InitDefaultConfig() {
 	config = Config{
 		StorageType: "registry",
 		Base:        BaseImage,
-		Registry:    RegistryConfig{DefaultRegistryHostname, DefaultRegistryPort, DefaultRegistryScheme},
+		Registry: RegistryConfig{
+			Hostname: DefaultRegistryHostname,
+			Port:     DefaultRegistryPort,
+			Scheme:   DefaultRegistryScheme,
+		},
+		Local: LocalConfig{filepath.Join(UserHomeDir(), DefaultLocalRootPart)},
+		Git:   GitConfig{Ref: DefaultGitRef},
+		Cache: CacheConfig{
+			Root: filepath.Join(UserHomeDir(), filepath.FromSlash(DefaultCacheRootPart)),
+			TTL:  DefaultCacheTTL,
+		},
+		Docker: DockerConfig{Endpoint: DefaultDockerEndpoint, Builder: BuilderAPI},
 	}
 }
 
@@ -99,11 +309,110 @@ func GetConfig() *Config {
 	return &config
 }
 
+// storage : the single storage configured by storage, registry, local and git keys.
+func (c *Config) storage() StorageConfig {
+	return StorageConfig{
+		Name:     c.StorageType,
+		Type:     c.StorageType,
+		Registry: c.Registry,
+		Local:    c.Local,
+		Git:      c.Git,
+	}
+}
+
+func newRegistryStorage(config RegistryConfig) (storageconnector.StorageReader, storageconnector.StorageWriter, error) {
+	registry, err := storageconnector.NewRegistryStorage(config.Hostname, config.Port, config.Scheme, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	registry.Token = config.TokenSource()
+	return registry, registry, nil
+}
+
+// newCachedStorage : wrap the registry into a cache, each registry is cached in its own directory.
+func newCachedStorage(cache CacheConfig, config RegistryConfig, registry storageconnector.StorageReader) (storageconnector.StorageReader, error) {
+	if cache.Root == "" {
+		return registry, nil
+	}
+
+	var ttl time.Duration
+	if cache.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(cache.TTL); err != nil {
+			return nil, fmt.Errorf("invalid cache.ttl '%s': %s", cache.TTL, err)
+		}
+	}
+
+	root := filepath.Join(cache.Root, fmt.Sprintf("%s_%s_%d", config.Scheme, config.Hostname, config.Port))
+	return storageconnector.NewCacheStorage(registry, root, ttl, cache.Offline)
+}
+
+// newStorageReader : create StorageReader of a single storage.
+func newStorageReader(storage StorageConfig, cache CacheConfig) (storageconnector.StorageReader, error) {
+	switch storage.Type {
+	case StorageTypeRegistry:
+		registry := storage.Registry
+		if registry.Port == 0 {
+			registry.Port = DefaultRegistryPort
+		}
+		if registry.Scheme == "" {
+			registry.Scheme = DefaultRegistryScheme
+		}
+		reader, _, err := newRegistryStorage(registry)
+		if err != nil {
+			return nil, err
+		}
+		return newCachedStorage(cache, registry, reader)
+	case StorageTypeLocal:
+		return storageconnector.NewLocalStorage(storage.Local.Root)
+	case StorageTypeGit:
+		return storageconnector.NewGitStorage(storage.Git.Path, storage.Git.Ref)
+	}
+
+	return nil, fmt.Errorf("unknown storage type '%s'", storage.Type)
+}
+
 // GetStorageReader : create new StorageReader by StorageType of given config.
+// Registry-storage is read through the cache, other storages are read directly: local and git
+// storages are local directories, available offline and cheap to read. Layered-storage is not
+// cached as a whole, its registry layers are cached each.
+// Layered-storage reads the storages of Storages list, a storage without a name is named by its type.
 func GetStorageReader(config Config) (storageconnector.StorageReader, error) {
+	if config.StorageType != StorageTypeLayered {
+		return newStorageReader(config.storage(), config.Cache)
+	}
+
+	if len(config.Storages) == 0 {
+		return nil, fmt.Errorf("storage type '%s' requires at least one storage in 'storages'", StorageTypeLayered)
+	}
+
+	layers := []storageconnector.Layer{}
+	for _, storage := range config.Storages {
+		if storage.Name == "" {
+			storage.Name = storage.Type
+		}
+		reader, err := newStorageReader(storage, config.Cache)
+		if err != nil {
+			return nil, fmt.Errorf("storage '%s': %s", storage.Name, err)
+		}
+		layers = append(layers, storageconnector.Layer{Name: storage.Name, Storage: reader})
+	}
+
+	return storageconnector.NewLayeredStorage(layers...), nil
+}
+
+// GetStorageWriter : create new StorageWriter by StorageType of given config.
+// Git-storage is read-only as it is pinned to a ref, Layered-storage is read-only as it is
+// not clear which storage to write to.
+func GetStorageWriter(config Config) (storageconnector.StorageWriter, error) {
 	switch config.StorageType {
 	case StorageTypeRegistry:
-		return storageconnector.NewRegistryStorage(config.Registry.Hostname, config.Registry.Port, config.Registry.Scheme, nil)
+		_, writer, err := newRegistryStorage(config.Registry)
+		return writer, err
+	case StorageTypeLocal:
+		return storageconnector.NewLocalStorage(config.Local.Root)
+	case StorageTypeGit, StorageTypeLayered:
+		return nil, fmt.Errorf("storage type '%s' is read-only", config.StorageType)
 	}
 
 	return nil, fmt.Errorf("unknown storage type '%s'", config.StorageType)
@@ -287,6 +596,11 @@ func toReprFromReflectValue(v reflect.Value) string {
 		return fmt.Sprintf("%v", n)
 	case reflect.String:
 		return v.String()
+	case reflect.Slice:
+		if s, ok := v.Interface().(fmt.Stringer); ok {
+			return s.String()
+		}
+		return fmt.Sprintf("%v", v.Interface())
 	default:
 		return v.String()
 	}
@@ -339,6 +653,10 @@ func valToReflectValue(setter reflect.Value, val string) (reflect.Value, error)
 		integerArg, err := strconv.Atoi(val)
 		return reflect.ValueOf(integerArg), err
 
+	case reflect.Bool:
+		boolArg, err := strconv.ParseBool(val)
+		return reflect.ValueOf(boolArg), err
+
 	default:
 		return reflect.ValueOf(val), nil
 	}
@@ -4,9 +4,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
+
+	"github.com/zalando-incubator/pazuzu/storageconnector"
 )
 
 func getConfig(t *testing.T) *Config {
@@ -143,3 +147,213 @@ func TestValueToReflectValue(t *testing.T) {
 		t.Error("Couldn't parse integer correctly.")
 	}
 }
+
+func dummySetterWithBool(value bool) {}
+
+func TestValueToReflectValueBool(t *testing.T) {
+	setter := reflect.ValueOf(dummySetterWithBool)
+	val, err := valToReflectValue(setter, "true")
+	if err != nil || !val.Bool() {
+		t.Error("Couldn't handle bool parameter type")
+	}
+	if _, err := valToReflectValue(setter, "maybe"); err == nil {
+		t.Error("Invalid bool should not be accepted")
+	}
+}
+
+func TestGetStorageReaderLayered(t *testing.T) {
+	root, err := ioutil.TempDir("", "pazuzu_layered")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, dir := range []string{"team/java", "public/java", "public/lein"} {
+		path := filepath.Join(root, filepath.FromSlash(dir))
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(filepath.Join(path, "meta.yml"), []byte("description: "+dir), 0644)
+		ioutil.WriteFile(filepath.Join(path, "Dockerfile"), []byte("RUN echo "+dir), 0644)
+	}
+
+	config, err := LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+storage: layered
+storages:
+  - name: team
+    type: local
+    local:
+      root: %s
+  - type: local
+    local:
+      root: %s
+`, filepath.Join(root, "team"), filepath.Join(root, "public"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repr := config.Storages.String(); repr != "team(local), (local)" {
+		t.Errorf("Wrong representation: %s", repr)
+	}
+
+	storage, err := GetStorageReader(config)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	metas, err := storage.SearchMeta(regexp.MustCompile(""))
+	if err != nil || len(metas) != 2 {
+		t.Fatalf("Wrong search result: %v, %v", metas, err)
+	}
+	if metas[0].Description != "team/java" || metas[0].Source != "team" || metas[1].Source != "local" {
+		t.Errorf("Wrong search result: %v", metas)
+	}
+
+	if _, err := GetStorageWriter(config); err == nil {
+		t.Error("Layered storage should be read-only")
+	}
+}
+
+func TestGetStorageReaderCache(t *testing.T) {
+	root, err := ioutil.TempDir("", "pazuzu_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	config, err := LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+storage: layered
+storages:
+  - type: registry
+    registry:
+      hostname: localhost
+  - type: local
+    local:
+      root: %s
+cache:
+  root: %s
+`, root, root)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := GetStorageReader(config)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+	layers := reflect.ValueOf(storage).Elem().FieldByName("Layers")
+	for i, expected := range []string{"*storageconnector.cacheStorage", "*storageconnector.localStorage"} {
+		layer := layers.Index(i).Interface().(storageconnector.Layer)
+		if kind := reflect.TypeOf(layer.Storage).String(); kind != expected {
+			t.Errorf("layer %s should be %s, was %s", layer.Name, expected, kind)
+		}
+	}
+}
+
+func TestNewCachedStorage(t *testing.T) {
+	root, err := ioutil.TempDir("", "pazuzu_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, scheme := range []string{"http", "https"} {
+		registry := RegistryConfig{Scheme: scheme, Hostname: "localhost", Port: 8080}
+		if _, err := newCachedStorage(CacheConfig{Root: root}, registry, nil); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+	}
+
+	dirs, err := ioutil.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, dir := range dirs {
+		names = append(names, dir.Name())
+	}
+	if expected := []string{"http_localhost_8080", "https_localhost_8080"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("registries should be cached in %v, were cached in %v", expected, names)
+	}
+}
+
+func setEnv(t *testing.T, values map[string]string) func() {
+	previous := map[string]string{}
+	for key, value := range values {
+		previous[key] = os.Getenv(key)
+		if err := os.Setenv(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for key, value := range previous {
+			os.Setenv(key, value)
+		}
+	}
+}
+
+func TestDockerConfig(t *testing.T) {
+	t.Run("Sets docker configs", func(t *testing.T) {
+		getConfig(t)
+		mirror := config.InitConfigFieldMirrors()
+		if err := mirror.SetConfig("docker.endpoint", "tcp://docker:2376"); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if err := mirror.SetConfig("docker.tls_verify", "true"); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if config.Docker.Endpoint != "tcp://docker:2376" || !config.Docker.TLSVerify {
+			t.Errorf("Unexpected docker configs: %v", config.Docker)
+		}
+	})
+
+	t.Run("Environment variables override the configuration", func(t *testing.T) {
+		defer setEnv(t, map[string]string{
+			DockerHostEnvVar:      "tcp://docker:2376",
+			DockerTLSVerifyEnvVar: "1",
+			DockerCertPathEnvVar:  "/etc/docker/client",
+		})()
+
+		docker := DockerConfig{Endpoint: DefaultDockerEndpoint, CertPath: "/certs"}.WithEnv()
+		expected := DockerConfig{Endpoint: "tcp://docker:2376", TLSVerify: true, CertPath: "/etc/docker/client"}
+		if docker != expected {
+			t.Errorf("Unexpected docker configs: %v", docker)
+		}
+	})
+
+	t.Run("Keeps the configuration without environment variables", func(t *testing.T) {
+		defer setEnv(t, map[string]string{DockerHostEnvVar: "", DockerTLSVerifyEnvVar: "", DockerCertPathEnvVar: ""})()
+
+		expected := DockerConfig{Endpoint: "unix:///run/docker.sock", CertPath: "/certs"}
+		if docker := expected.WithEnv(); docker != expected {
+			t.Errorf("Unexpected docker configs: %v", docker)
+		}
+	})
+}
+
+func TestNewDockerClient(t *testing.T) {
+	t.Run("Connects to the default endpoint", func(t *testing.T) {
+		client, err := NewDockerClient(DockerConfig{})
+		if err != nil || client.Endpoint() != DefaultDockerEndpoint {
+			t.Errorf("Unexpected client: %v", err)
+		}
+	})
+
+	t.Run("Requires client certificates for TLS", func(t *testing.T) {
+		certPath, err := ioutil.TempDir("", "pazuzu_certs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(certPath)
+
+		_, err = NewDockerClient(DockerConfig{Endpoint: "tcp://docker:2376", TLSVerify: true, CertPath: certPath})
+		if err == nil || !strings.Contains(err.Error(), certPath) {
+			t.Errorf("should fail for missing certificates: %v", err)
+		}
+	})
+
+	t.Run("Fails for invalid endpoints", func(t *testing.T) {
+		if _, err := NewDockerClient(DockerConfig{Endpoint: "ftp://docker"}); err == nil {
+			t.Error("should fail")
+		}
+	})
+}
@@ -2,18 +2,42 @@ package pazuzu
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
 
+	"github.com/docker/docker/builder/dockerfile/command"
 	"github.com/docker/docker/builder/dockerfile/parser"
 
 	"github.com/zalando-incubator/pazuzu/shared"
 )
 
-var ErrInvalidCopyCmdSyntax = fmt.Errorf("Invalid 'COPY' command syntax")
+var ErrInvalidCopyCmdSyntax = fmt.Errorf("Invalid 'COPY' or 'ADD' command syntax")
+
+// comment in front of the image configuration, also used for the instructions not coming from
+// features in optimising mode
+const imageComment = "image configuration"
+
+// sources of ADD which are downloaded rather than taken from the build context
+var remoteSourceRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+
+var (
+	paramNameRegexp  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	plainValueRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.,:/@+=-]*$`)
+	labelKeyRegexp   = regexp.MustCompile(`^[a-zA-Z0-9_.,:/@+-]+$`)
+	portRegexp       = regexp.MustCompile(`^[0-9]+(-[0-9]+)?(/(tcp|udp))?$`)
+)
 
 type DockerfileWriter struct {
-	buf *bytes.Buffer
+	Optimize bool // merge layers, see optimize
+
+	buf          *bytes.Buffer
+	cmdSet       bool   // CMD or ENTRYPOINT was written
+	feature      string // feature the instructions are written for
+	instructions []instruction
 }
 
 func NewDockerfileWriter() *DockerfileWriter {
@@ -22,6 +46,17 @@ func NewDockerfileWriter() *DockerfileWriter {
 }
 
 func (c *DockerfileWriter) AppendRaw(chunk string) error {
+	if c.Optimize {
+		ast, err := parseDockerfile(chunk)
+		if err != nil {
+			return err
+		}
+		for _, node := range ast.Children {
+			c.instructions = append(c.instructions, instruction{node: node, feature: c.feature})
+		}
+		return nil
+	}
+
 	_, err := c.buf.WriteString(chunk + "\n")
 	if err != nil {
 		return err
@@ -30,45 +65,298 @@ func (c *DockerfileWriter) AppendRaw(chunk string) error {
 	return nil
 }
 
+func parseDockerfile(content string) (*parser.Node, error) {
+	d := parser.Directive{LookingForDirectives: true}
+	parser.SetEscapeToken(parser.DefaultEscapeToken, &d)
+
+	return parser.Parse(strings.NewReader(content), &d)
+}
+
+// AppendStage starts a new stage of the Dockerfile, name is optional.
+func (c *DockerfileWriter) AppendStage(image string, name string) error {
+	c.cmdSet = false
+	if name == "" {
+		return c.AppendRaw(fmt.Sprintf("FROM %s\n", image))
+	}
+	return c.AppendRaw(fmt.Sprintf("FROM %s AS %s\n", image, name))
+}
+
+// AppendArtifacts copies the artifacts of a build-only feature from the given stage to the same
+// paths of the current stage.
+func (c *DockerfileWriter) AppendArtifacts(feature shared.Feature, stage string) error {
+	c.feature = feature.Meta.Name
+	defer func() { c.feature = "" }()
+
+	for _, artifact := range feature.Meta.Artifacts {
+		if !path.IsAbs(artifact) || strings.ContainsAny(artifact, "\r\n") {
+			return fmt.Errorf("artifact '%s' of feature '%s' must be an absolute path", artifact, feature.Meta.Name)
+		}
+
+		args := artifact + " " + artifact
+		if strings.ContainsAny(artifact, " \t") {
+			array, err := jsonArray([]string{artifact, artifact})
+			if err != nil {
+				return err
+			}
+			args = array
+		}
+		if err := c.AppendRaw(fmt.Sprintf("COPY --from=%s %s", stage, args)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AppendParams declares the parameters of a feature as build arguments, so the snippet can use
+// them as ${name}. values override the defaults, there must be no values for undeclared parameters.
+func (c *DockerfileWriter) AppendParams(feature shared.Feature, values map[string]string) error {
+	c.feature = feature.Meta.Name
+	defer func() { c.feature = "" }()
+
+	declared := map[string]bool{}
+	for _, param := range feature.Meta.Params {
+		if !paramNameRegexp.MatchString(param.Name) {
+			return fmt.Errorf("invalid parameter name '%s' of feature '%s'", param.Name, feature.Meta.Name)
+		}
+		declared[param.Name] = true
+	}
+
+	unknown := []string{}
+	for name := range values {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("feature '%s' has no parameters %s", feature.Meta.Name, strings.Join(unknown, ", "))
+	}
+
+	for _, param := range feature.Meta.Params {
+		value, ok := values[param.Name]
+		if !ok {
+			value = param.Default
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("value of parameter '%s' of feature '%s' must be a single line", param.Name, feature.Meta.Name)
+		}
+		if err := c.AppendRaw(fmt.Sprintf("ARG %s=%s", param.Name, quoteValue(value))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ClearParams empties the build arguments of the parameters of a feature after its snippet.
+// ARG stays in scope for the rest of the stage, later features using a parameter of the same
+// name without declaring it would get the value of this one otherwise.
+func (c *DockerfileWriter) ClearParams(feature shared.Feature) error {
+	c.feature = feature.Meta.Name
+	defer func() { c.feature = "" }()
+
+	for _, param := range feature.Meta.Params {
+		if err := c.AppendRaw(fmt.Sprintf("ARG %s=", param.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AppendImage writes the runtime configuration of the image, preceded by a comment like the
+// instructions of features.
+func (c *DockerfileWriter) AppendImage(image ImageConfig) error {
+	lines := []string{}
+
+	for _, key := range sortedKeys(image.Env) {
+		if !paramNameRegexp.MatchString(key) {
+			return fmt.Errorf("invalid environment variable name '%s'", key)
+		}
+		lines = append(lines, fmt.Sprintf("ENV %s=%s", key, quoteValue(image.Env[key])))
+	}
+	for _, key := range sortedKeys(image.Labels) {
+		if !labelKeyRegexp.MatchString(key) {
+			return fmt.Errorf("invalid label '%s'", key)
+		}
+		lines = append(lines, fmt.Sprintf("LABEL %s=%s", key, quoteValue(image.Labels[key])))
+	}
+	for _, port := range image.Expose {
+		if !portRegexp.MatchString(port) {
+			return fmt.Errorf("invalid port '%s'", port)
+		}
+		lines = append(lines, "EXPOSE "+port)
+	}
+	if image.Workdir != "" {
+		lines = append(lines, "WORKDIR "+image.Workdir)
+	}
+	if image.User != "" {
+		lines = append(lines, "USER "+image.User)
+	}
+	if len(image.Entrypoint) > 0 {
+		entrypoint, err := jsonArray(image.Entrypoint)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, "ENTRYPOINT "+entrypoint)
+	}
+	if len(image.Cmd) > 0 {
+		cmd, err := jsonArray(image.Cmd)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, "CMD "+cmd)
+	}
+
+	if err := c.AppendRaw("# " + imageComment + "\n"); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if strings.ContainsAny(line, "\r\n") {
+			return fmt.Errorf("image configuration must not span lines: %s", line)
+		}
+		if err := c.AppendRaw(line); err != nil {
+			return err
+		}
+	}
+	if len(image.Entrypoint) > 0 || len(image.Cmd) > 0 {
+		c.cmdSet = true
+	}
+
+	return nil
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsonArray formats arguments of the exec and JSON forms of commands.
+func jsonArray(args []string) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(args); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// quoteValue quotes values which would be split or expanded by Docker otherwise.
+func quoteValue(value string) string {
+	if plainValueRegexp.MatchString(value) {
+		return value
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// fixCopyCmd rewrites sources of COPY and ADD commands to the folder of the feature in the build
+// context. Flags, URLs and the JSON form are kept, COPY --from copies from another build stage
+// and is left as it is.
 func fixCopyCmd(node *parser.Node, feature shared.Feature) (string, error) {
-	srcNode := node.Next
-	if srcNode == nil {
-		return "", ErrInvalidCopyCmdSyntax
+	for _, flag := range node.Flags {
+		if flag == "--from" || strings.HasPrefix(flag, "--from=") {
+			return node.Original, nil
+		}
 	}
-	dstNode := srcNode.Next
-	if dstNode == nil {
+
+	args := []string{}
+	for arg := node.Next; arg != nil; arg = arg.Next {
+		args = append(args, arg.Value)
+	}
+	if len(args) < 2 {
 		return "", ErrInvalidCopyCmdSyntax
 	}
 
-	fixedCmd := fmt.Sprintf("COPY %s/%s %s", feature.Meta.Name, srcNode.Value, dstNode.Value)
+	for i, src := range args[:len(args)-1] {
+		if remoteSourceRegexp.MatchString(src) {
+			continue
+		}
+		clean := path.Clean(src)
+		if path.IsAbs(src) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return "", fmt.Errorf("%s source '%s' of feature '%s' is outside of the feature folder",
+				strings.ToUpper(node.Value), src, feature.Meta.Name)
+		}
+		args[i] = feature.Meta.Name + "/" + src
+	}
+
+	words := append([]string{strings.ToUpper(node.Value)}, node.Flags...)
+	if node.Attributes["json"] {
+		array, err := jsonArray(args)
+		if err != nil {
+			return "", err
+		}
+		words = append(words, array)
+	} else {
+		words = append(words, args...)
+	}
 
-	return fixedCmd, nil
+	return strings.Join(words, " "), nil
 }
 
 func (c *DockerfileWriter) AppendFeature(feature shared.Feature) error {
-	d := parser.Directive{LookingForDirectives: true}
-	parser.SetEscapeToken(parser.DefaultEscapeToken, &d)
-
-	ast, err := parser.Parse(strings.NewReader(feature.Snippet), &d)
+	ast, err := parseDockerfile(feature.Snippet)
 	if err != nil {
 		return err
 	}
 
+	c.feature = feature.Meta.Name
+	defer func() { c.feature = "" }()
+
 	for _, cmdNode := range ast.Children {
-		if cmdNode.Value == "copy" {
+		if cmdNode.Value == command.Cmd || cmdNode.Value == command.Entrypoint {
+			c.cmdSet = true
+		}
+
+		if cmdNode.Value == command.Copy || cmdNode.Value == command.Add {
 			fixedCmd, err := fixCopyCmd(cmdNode, feature)
 			if err != nil {
 				return err
 			}
-			c.AppendRaw(fixedCmd)
+			err = c.AppendRaw(fixedCmd)
 		} else {
-			c.AppendRaw(cmdNode.Original)
+			err = c.AppendRaw(cmdNode.Original)
+		}
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// Bytes returns the Dockerfile. In optimising mode, every instruction is preceded by a comment
+// naming the features it comes from.
 func (c *DockerfileWriter) Bytes() []byte {
-	return c.buf.Bytes()
+	if !c.Optimize {
+		return c.buf.Bytes()
+	}
+
+	var buf bytes.Buffer
+	previous := ""
+	for _, l := range optimize(c.instructions) {
+		if l.node != nil && l.node.Value == command.From {
+			if buf.Len() > 0 {
+				buf.WriteString("\n")
+			}
+			buf.WriteString(l.String() + "\n\n")
+			previous = ""
+			continue
+		}
+
+		comment := strings.Join(l.features, ", ")
+		if comment == "" {
+			comment = imageComment
+		}
+		if comment != previous {
+			buf.WriteString("# " + comment + "\n")
+		}
+		previous = comment
+		buf.WriteString(l.String() + "\n")
+	}
+	return buf.Bytes()
 }
@@ -59,3 +59,52 @@ func TestDockerfileWriter(t *testing.T) {
 		}
 	}
 }
+
+func TestDockerfileWriterCopyAndAdd(t *testing.T) {
+	feature := shared.Feature{Meta: shared.FeatureMeta{Name: "java"}}
+
+	fixed := []struct {
+		name     string
+		snippet  string
+		expected string
+	}{
+		{"Copy with flags", "COPY --chown=user:group a b", "COPY --chown=user:group java/a b"},
+		{"Copy multiple sources", "COPY a b c /dst/", "COPY java/a java/b java/c /dst/"},
+		{"Copy JSON form", `COPY ["a b", "c", "/dst/"]`, `COPY ["java/a b","java/c","/dst/"]`},
+		{"Copy from stage", "COPY --from=builder /app/lib.jar /lib/", "COPY --from=builder /app/lib.jar /lib/"},
+		{"Copy folder", "COPY . /opt/java", "COPY java/. /opt/java"},
+		{"Add local file", "ADD jdk.tar.gz /opt", "ADD java/jdk.tar.gz /opt"},
+		{"Add URL", "ADD https://example.org/jdk.tar.gz conf /opt/", "ADD https://example.org/jdk.tar.gz java/conf /opt/"},
+		{"Lowercase command", "copy a b", "COPY java/a b"},
+	}
+	for _, c := range fixed {
+		t.Run(c.name, func(t *testing.T) {
+			writer := NewDockerfileWriter()
+			feature.Snippet = c.snippet
+			if err := writer.AppendFeature(feature); err != nil {
+				t.Fatalf("Feature should be appended: %s", err)
+			}
+			if dockerfile := strings.TrimSpace(string(writer.Bytes())); dockerfile != c.expected {
+				t.Errorf("Expected '%s', got '%s'", c.expected, dockerfile)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name    string
+		snippet string
+	}{
+		{"Missing destination", "COPY a"},
+		{"Absolute source", "COPY /etc/passwd /tmp/"},
+		{"Source outside of the feature", "ADD ../node/app.js /app/"},
+		{"Hidden parent folder", `COPY ["conf/../../node", "/app/"]`},
+	}
+	for _, c := range invalid {
+		t.Run(c.name, func(t *testing.T) {
+			feature.Snippet = c.snippet
+			if err := NewDockerfileWriter().AppendFeature(feature); err == nil {
+				t.Error("Feature should not be appended")
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package pazuzu
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var (
 	ErrNoValidPazuzufile      = errors.New("No valid Pazuzufile provided")
@@ -11,3 +14,14 @@ var (
 	ErrInitAndAddAreSpecified = errors.New("Conflict: both `add` and `init` parameters are specified")
 	ErrInvalidConfigValue     = errors.New("Can not parse value to required type")
 )
+
+// FeatureErrors collects errors of all the features which failed, so they are reported at once.
+type FeatureErrors []error
+
+func (e FeatureErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
@@ -0,0 +1,116 @@
+package pazuzu
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+// Labels of the generated images, so the features an image was built from can be told by any
+// docker tooling. The creation time is left out to keep the Dockerfile reproducible.
+const (
+	LabelFeatures = "org.zalando.pazuzu.features" // comma-separated names in Dockerfile order
+	LabelBase     = "org.zalando.pazuzu.base"
+	LabelVersion  = "org.zalando.pazuzu.version"
+
+	// followed by the feature name and .hash or .updated_at
+	labelFeaturePrefix = "org.zalando.pazuzu.feature."
+
+	labelOCIBaseName    = "org.opencontainers.image.base.name"
+	labelOCIBaseDigest  = "org.opencontainers.image.base.digest"
+	labelOCIDescription = "org.opencontainers.image.description"
+)
+
+// ImageFeatures describes the features an image was composed of, read from its labels.
+type ImageFeatures struct {
+	Base     string
+	Version  string          // of pazuzu, empty if not known
+	Features []LockedFeature // in Dockerfile order
+}
+
+// imageLabels returns the labels describing the features of the final image. Build-only features
+// are included, as their artifacts end up in the image.
+func imageLabels(base string, version string, features []shared.Feature) map[string]string {
+	lock := NewLock(features)
+	names := make([]string, 0, len(lock.Features))
+	labels := map[string]string{
+		LabelBase:        base,
+		labelOCIBaseName: base,
+	}
+
+	for _, feature := range lock.Features {
+		names = append(names, feature.Name)
+		labels[labelFeaturePrefix+feature.Name+".hash"] = feature.Hash
+		if feature.UpdatedAt != "" {
+			labels[labelFeaturePrefix+feature.Name+".updated_at"] = feature.UpdatedAt
+		}
+	}
+	labels[LabelFeatures] = strings.Join(names, ",")
+	labels[labelOCIDescription] = "Composed by pazuzu of " + strings.Join(names, ", ")
+
+	if parts := strings.SplitN(base, "@", 2); len(parts) == 2 {
+		labels[labelOCIBaseDigest] = parts[1]
+	}
+	if version != "" {
+		labels[LabelVersion] = version
+	}
+	return labels
+}
+
+// ParseImageLabels reads the features of an image from its labels. ok is false for images which
+// were not composed by pazuzu.
+func ParseImageLabels(labels map[string]string) (features *ImageFeatures, ok bool) {
+	value, ok := labels[LabelFeatures]
+	if !ok {
+		return nil, false
+	}
+
+	features = &ImageFeatures{
+		Base:     labels[LabelBase],
+		Version:  labels[LabelVersion],
+		Features: []LockedFeature{},
+	}
+	for _, name := range strings.Split(value, ",") {
+		if name == "" {
+			continue
+		}
+		features.Features = append(features.Features, LockedFeature{
+			Name:      name,
+			UpdatedAt: labels[labelFeaturePrefix+name+".updated_at"],
+			Hash:      labels[labelFeaturePrefix+name+".hash"],
+		})
+	}
+	return features, true
+}
+
+// InspectImage reads the features of a local image.
+func (p *Pazuzu) InspectImage(name string) (*ImageFeatures, error) {
+	builder, err := p.imageBuilder()
+	if err != nil {
+		return nil, err
+	}
+
+	labels, err := builder.Labels(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not inspect image %s: %s", name, err)
+	}
+
+	features, ok := ParseImageLabels(labels)
+	if !ok {
+		return nil, fmt.Errorf("image %s was not composed by pazuzu", name)
+	}
+	return features, nil
+}
+
+// mergeLabels returns the labels of the image configuration together with the generated ones,
+// the configured labels take precedence.
+func mergeLabels(generated map[string]string, configured map[string]string) map[string]string {
+	labels := map[string]string{}
+	for _, values := range []map[string]string{generated, configured} {
+		for key, value := range values {
+			labels[key] = value
+		}
+	}
+	return labels
+}
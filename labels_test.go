@@ -0,0 +1,88 @@
+package pazuzu
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+// withoutLabels removes the generated labels from a Dockerfile.
+func withoutLabels(dockerfile []byte) string {
+	var lines []string
+	for _, line := range strings.SplitAfter(string(dockerfile), "\n") {
+		if !strings.HasPrefix(line, "LABEL org.zalando.pazuzu.") && !strings.HasPrefix(line, "LABEL org.opencontainers.image.") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+func TestGenerateLabels(t *testing.T) {
+	curl := shared.NewFeature_str("curl", "", "", nil, "RUN install curl", "")
+	jdk := shared.NewFeature_str("jdk", "", "", []string{"curl"}, "RUN download jdk", "")
+	jdk.Meta.BuildOnly = true
+	jdk.Meta.Artifacts = []string{"/opt/jdk"}
+	lein := shared.NewFeature_str("lein", "", "", []string{"jdk"}, "RUN install lein", "")
+	lein.Meta.UpdatedAt = time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)
+	storage := NewMapStorage(curl, jdk, lein)
+
+	pazuzu := Pazuzu{StorageReader: storage, Version: "0.2", Image: ImageConfig{
+		Labels: map[string]string{labelOCIDescription: "Clojure CI"},
+	}}
+	if err := pazuzu.Generate("ubuntu:16.04@sha256:0123", []string{"lein"}); err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+	dockerfile := string(pazuzu.Dockerfile)
+
+	t.Run("Labels the features of the final image", func(t *testing.T) {
+		expected := []string{
+			"LABEL org.opencontainers.image.base.digest=sha256:0123\n",
+			"LABEL org.opencontainers.image.base.name=ubuntu:16.04@sha256:0123\n",
+			"LABEL org.zalando.pazuzu.base=ubuntu:16.04@sha256:0123\n",
+			"LABEL org.zalando.pazuzu.feature.jdk.hash=" + FeatureHash(jdk) + "\n",
+			"LABEL org.zalando.pazuzu.feature.lein.hash=" + FeatureHash(lein) + "\n",
+			"LABEL org.zalando.pazuzu.feature.lein.updated_at=2017-06-01T10:00:00Z\n",
+			"LABEL org.zalando.pazuzu.features=jdk,lein\n",
+			"LABEL org.zalando.pazuzu.version=0.2\n",
+		}
+		for _, label := range expected {
+			if !strings.Contains(dockerfile, label) {
+				t.Errorf("missing %s in Dockerfile:\n%s", label, dockerfile)
+			}
+		}
+		if strings.Contains(dockerfile, "feature.curl") {
+			t.Errorf("build stage features should not be labeled:\n%s", dockerfile)
+		}
+	})
+
+	t.Run("Labels of the image configuration take precedence", func(t *testing.T) {
+		if !strings.Contains(dockerfile, `LABEL org.opencontainers.image.description="Clojure CI"`) || strings.Contains(dockerfile, "Composed by pazuzu") {
+			t.Errorf("wrong Dockerfile:\n%s", dockerfile)
+		}
+	})
+
+	t.Run("Reads the features back from the labels", func(t *testing.T) {
+		labels := imageLabels("ubuntu", "0.2", []shared.Feature{jdk, lein})
+		features, ok := ParseImageLabels(labels)
+		expected := &ImageFeatures{
+			Base:    "ubuntu",
+			Version: "0.2",
+			Features: []LockedFeature{
+				{Name: "jdk", Hash: FeatureHash(jdk)},
+				{Name: "lein", UpdatedAt: "2017-06-01T10:00:00Z", Hash: FeatureHash(lein)},
+			},
+		}
+		if !ok || !reflect.DeepEqual(features, expected) {
+			t.Errorf("wrong features: %v", features)
+		}
+	})
+
+	t.Run("Does not read images of others", func(t *testing.T) {
+		if _, ok := ParseImageLabels(map[string]string{"team": "ci"}); ok {
+			t.Error("should not read features")
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package pazuzu
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+// Lock records the features a Pazuzufile was resolved to, in Dockerfile order, so a changed
+// storage content is noticed instead of silently producing a different image.
+type Lock struct {
+	Features []LockedFeature `yaml:"features"`
+}
+
+// LockedFeature is a resolved feature of a Lock. Hash covers everything the feature adds to the
+// build: snippet, test snippet, asset files and artifacts of build-only features.
+type LockedFeature struct {
+	Name      string `yaml:"name"`
+	UpdatedAt string `yaml:"updated_at,omitempty"`
+	Hash      string `yaml:"hash"`
+}
+
+// LockMismatchError is returned when the resolved features differ from a Lock.
+type LockMismatchError struct {
+	Differences []string
+}
+
+func (e *LockMismatchError) Error() string {
+	return fmt.Sprintf("resolved features differ from the lock:\n  %s", strings.Join(e.Differences, "\n  "))
+}
+
+// NewLock creates the lock of resolved features.
+func NewLock(features []shared.Feature) Lock {
+	lock := Lock{Features: []LockedFeature{}}
+	for _, feature := range features {
+		locked := LockedFeature{Name: feature.Meta.Name, Hash: FeatureHash(feature)}
+		if !feature.Meta.UpdatedAt.IsZero() {
+			locked.UpdatedAt = feature.Meta.UpdatedAt.UTC().Format(time.RFC3339)
+		}
+		lock.Features = append(lock.Features, locked)
+	}
+	return lock
+}
+
+// FeatureHash returns the SHA-256 of the content of a feature, including its dependencies and
+// parameter defaults.
+func FeatureHash(feature shared.Feature) string {
+	hash := sha256.New()
+	writeField := func(value []byte) {
+		fmt.Fprintf(hash, "%d:", len(value))
+		hash.Write(value)
+	}
+
+	writeField([]byte(feature.Snippet))
+	writeField([]byte(feature.TestSnippet))
+
+	paths := make([]string, 0, len(feature.Files))
+	for path := range feature.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		writeField([]byte(path))
+		writeField([]byte(fmt.Sprint(feature.Files[path].Executable)))
+		writeField(feature.Files[path].Content)
+	}
+
+	// meta changing the Dockerfile, left out if empty like build-only below
+	if len(feature.Meta.Dependencies) > 0 {
+		writeField([]byte("dependencies"))
+		for _, dependency := range feature.Meta.Dependencies {
+			writeField([]byte(dependency))
+		}
+	}
+	if len(feature.Meta.Params) > 0 {
+		writeField([]byte("params"))
+		for _, param := range feature.Meta.Params {
+			writeField([]byte(param.Name))
+			writeField([]byte(param.Default))
+		}
+	}
+
+	// left out for other features, so their hashes do not depend on it
+	if feature.Meta.BuildOnly {
+		writeField([]byte("build-only"))
+		for _, artifact := range feature.Meta.Artifacts {
+			writeField([]byte(artifact))
+		}
+	}
+
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// Compare returns the differences between the lock and the features resolved now. changed lists
+// features of both whose content differs, other lists added, removed and reordered features.
+func (l Lock) Compare(current Lock) (changed []string, other []string) {
+	locked := map[string]LockedFeature{}
+	for _, feature := range l.Features {
+		locked[feature.Name] = feature
+	}
+	resolved := map[string]bool{}
+
+	for _, feature := range current.Features {
+		resolved[feature.Name] = true
+		previous, ok := locked[feature.Name]
+		switch {
+		case !ok:
+			other = append(other, fmt.Sprintf("feature '%s' is not locked", feature.Name))
+		case previous.Hash != feature.Hash:
+			changed = append(changed, fmt.Sprintf("feature '%s' changed (locked %s, updated %s)",
+				feature.Name, orUnknown(previous.UpdatedAt), orUnknown(feature.UpdatedAt)))
+		}
+	}
+	for _, feature := range l.Features {
+		if !resolved[feature.Name] {
+			other = append(other, fmt.Sprintf("locked feature '%s' is not used anymore", feature.Name))
+		}
+	}
+
+	if len(other) == 0 && !sameOrder(l.Features, current.Features) {
+		other = append(other, "features are resolved in a different order")
+	}
+
+	return changed, other
+}
+
+// Verify fails if the features resolved now differ from the lock in any way.
+func (l Lock) Verify(current Lock) error {
+	changed, other := l.Compare(current)
+	if differences := append(changed, other...); len(differences) > 0 {
+		return &LockMismatchError{Differences: differences}
+	}
+	return nil
+}
+
+func sameOrder(a []LockedFeature, b []LockedFeature) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name {
+			return false
+		}
+	}
+	return true
+}
+
+func orUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+func ReadLock(reader io.Reader) (Lock, error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return Lock{}, err
+	}
+
+	lock := Lock{}
+	if err := yaml.Unmarshal(content, &lock); err != nil {
+		return Lock{}, err
+	}
+	return lock, nil
+}
+
+func WriteLock(writer io.Writer, lock Lock) error {
+	data, err := yaml.Marshal(lock)
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(data)
+	return err
+}
@@ -0,0 +1,87 @@
+package pazuzu
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+func TestLock(t *testing.T) {
+	java := shared.NewFeature_str("java", "", "", nil, "RUN install java", "")
+	java.Meta.UpdatedAt = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	lein := shared.NewFeature_str("lein", "", "", []string{"java"}, "RUN install lein", "")
+	lock := NewLock([]shared.Feature{java, lein})
+
+	t.Run("Records resolved features", func(t *testing.T) {
+		if len(lock.Features) != 2 || lock.Features[0].Name != "java" || lock.Features[1].Name != "lein" {
+			t.Fatalf("wrong features: %v", lock.Features)
+		}
+		if lock.Features[0].UpdatedAt != "2017-03-01T12:00:00Z" || lock.Features[1].UpdatedAt != "" {
+			t.Errorf("wrong update times: %v", lock.Features)
+		}
+	})
+
+	t.Run("Hashes all the content", func(t *testing.T) {
+		changed := java
+		changed.TestSnippet = "@test"
+		withFile := java
+		withFile.Files = map[string]shared.FeatureFile{"java.conf": {Content: []byte("-Xmx1g")}}
+		executable := java
+		executable.Files = map[string]shared.FeatureFile{"java.conf": {Content: []byte("-Xmx1g"), Executable: true}}
+
+		dependent := java
+		dependent.Meta.Dependencies = []string{"curl"}
+		withParam := java
+		withParam.Meta.Params = []shared.FeatureParam{{Name: "version", Default: "8"}}
+		otherDefault := java
+		otherDefault.Meta.Params = []shared.FeatureParam{{Name: "version", Default: "11"}}
+
+		hashes := map[string]bool{}
+		for _, feature := range []shared.Feature{java, changed, withFile, executable, dependent, withParam, otherDefault} {
+			hashes[FeatureHash(feature)] = true
+		}
+		if len(hashes) != 7 {
+			t.Errorf("all the content should be hashed: %v", hashes)
+		}
+		if FeatureHash(java) != lock.Features[0].Hash {
+			t.Error("hash should be stable")
+		}
+	})
+
+	t.Run("Compares changed content", func(t *testing.T) {
+		lein.Snippet = "RUN install lein 2"
+		changed, other := lock.Compare(NewLock([]shared.Feature{java, lein}))
+		if len(changed) != 1 || len(other) != 0 {
+			t.Errorf("wrong differences: %v, %v", changed, other)
+		}
+	})
+
+	t.Run("Compares features and order", func(t *testing.T) {
+		node := shared.NewFeature_str("node", "", "", nil, "RUN install node", "")
+		if changed, other := lock.Compare(NewLock([]shared.Feature{java, node})); len(changed) != 0 || len(other) != 2 {
+			t.Errorf("wrong differences: %v, %v", changed, other)
+		}
+
+		reordered := Lock{Features: []LockedFeature{lock.Features[1], lock.Features[0]}}
+		if err := lock.Verify(reordered); err == nil {
+			t.Error("different order should be reported")
+		}
+		if err := lock.Verify(lock); err != nil {
+			t.Errorf("should not fail: %s", err)
+		}
+	})
+
+	t.Run("Reads written lock", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := WriteLock(&buf, lock); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		read, err := ReadLock(&buf)
+		if err != nil || !reflect.DeepEqual(read, lock) {
+			t.Errorf("lock should be kept: %v, %v", read, err)
+		}
+	})
+}
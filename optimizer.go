@@ -0,0 +1,306 @@
+package pazuzu
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/builder/dockerfile/command"
+	"github.com/docker/docker/builder/dockerfile/parser"
+)
+
+// The layer optimiser rewrites the instructions collected by DockerfileWriter to produce fewer
+// layers, while keeping the result of every feature:
+//
+//   - consecutive RUN instructions in shell form are merged into one, every command runs in its
+//     own subshell, so changed directories or variables do not leak into the next command.
+//     RUN instructions with comments or heredocs are kept as they are, any other instruction
+//     ends the merging.
+//   - consecutive RUN instructions which only install packages with apt-get, apk or yum and the
+//     same install command and options are merged into one install of all the packages. The
+//     index is updated and cleaned up only as the merged installs did, later commands might rely
+//     on it. Nothing is moved in front of other commands, as they might prepare the installs.
+//
+// Every instruction is preceded by a comment naming the features it comes from.
+
+// instruction is an instruction collected by DockerfileWriter in optimising mode.
+type instruction struct {
+	node    *parser.Node
+	feature string // empty for instructions not coming from a feature
+}
+
+var packageNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9.+:=~_-]*$`)
+
+// packageManager knows the commands of a package manager which can be merged.
+type packageManager struct {
+	name     string
+	updates  [][]string      // commands updating the package index
+	cleanups [][]string      // commands cleaning up after installs
+	installs [][]string      // install commands, followed by options and packages
+	options  map[string]bool // options allowed for installs
+}
+
+var packageManagers = []*packageManager{
+	{
+		name:     "apt-get",
+		updates:  [][]string{{"apt-get", "update"}, {"apt-get", "-q", "update"}, {"apt-get", "-qq", "update"}, {"apt", "update"}},
+		cleanups: [][]string{{"apt-get", "clean"}, {"rm", "-rf", "/var/lib/apt/lists/*"}},
+		installs: [][]string{{"apt-get", "install"}, {"apt", "install"}, {"DEBIAN_FRONTEND=noninteractive", "apt-get", "install"}},
+		options: map[string]bool{"-y": true, "--yes": true, "--assume-yes": true, "-q": true, "-qq": true,
+			"--quiet": true, "--no-install-recommends": true},
+	},
+	{
+		name:     "apk",
+		updates:  [][]string{{"apk", "update"}},
+		cleanups: [][]string{{"rm", "-rf", "/var/cache/apk/*"}},
+		installs: [][]string{{"apk", "add"}},
+		options:  map[string]bool{"--no-cache": true, "--update": true, "-U": true, "-q": true, "--quiet": true},
+	},
+	{
+		name:     "yum",
+		updates:  [][]string{{"yum", "makecache"}, {"yum", "-y", "update"}, {"yum", "update", "-y"}},
+		cleanups: [][]string{{"yum", "clean", "all"}, {"rm", "-rf", "/var/cache/yum"}},
+		installs: [][]string{{"yum", "install"}, {"yum", "-y", "install"}},
+		options:  map[string]bool{"-y": true, "--assumeyes": true, "-q": true, "--quiet": true},
+	},
+}
+
+// packageInstall is a RUN command which does nothing else than installing packages, optionally
+// updating the index before and cleaning up after. The updates and cleanups are kept as written,
+// later commands might depend on the package index.
+type packageInstall struct {
+	manager  *packageManager
+	command  []string // install command as written
+	options  []string // as written
+	packages []string
+	updates  []string // update commands in front of the install
+	cleanups []string // cleanup commands after the install
+}
+
+// parsePackageInstall returns the package install of a RUN command, ok is false for other commands.
+func parsePackageInstall(cmd string) (install packageInstall, ok bool) {
+	if strings.ContainsAny(cmd, ";|<>()$`\"'\\\n#") {
+		return packageInstall{}, false
+	}
+
+	segments := strings.Split(cmd, "&&")
+	for _, manager := range packageManagers {
+		if install, ok := manager.parse(segments); ok {
+			return install, true
+		}
+	}
+	return packageInstall{}, false
+}
+
+// parse accepts updates, followed by installs with the same install command, followed by cleanups.
+func (m *packageManager) parse(segments []string) (packageInstall, bool) {
+	install := packageInstall{manager: m}
+
+	for _, segment := range segments {
+		words := strings.Fields(segment)
+		switch {
+		case matchesAny(words, m.updates) && install.command == nil:
+			install.updates = append(install.updates, strings.Join(words, " "))
+			continue
+		case matchesAny(words, m.cleanups) && install.command != nil:
+			install.cleanups = append(install.cleanups, strings.Join(words, " "))
+			continue
+		}
+
+		command, args, ok := m.installArgs(words)
+		if !ok || len(install.cleanups) > 0 || (install.command != nil && !equalWords(command, install.command)) {
+			return packageInstall{}, false
+		}
+		install.command = command
+		for _, arg := range args {
+			switch {
+			case m.options[arg]:
+				install.options = appendMissing(install.options, arg)
+			case packageNameRegexp.MatchString(arg):
+				install.packages = append(install.packages, arg)
+			default:
+				return packageInstall{}, false
+			}
+		}
+	}
+
+	return install, install.command != nil && len(install.packages) > 0
+}
+
+// installArgs splits an install command into the command itself and its options and packages.
+func (m *packageManager) installArgs(words []string) ([]string, []string, bool) {
+	for _, install := range m.installs {
+		if len(words) >= len(install) && equalWords(words[:len(install)], install) {
+			return install, words[len(install):], true
+		}
+	}
+	return nil, nil, false
+}
+
+// mergeable tells whether the packages of another install can be added to this one, which needs
+// the same install command and options.
+func (i packageInstall) mergeable(other packageInstall) bool {
+	if i.manager != other.manager || !equalWords(i.command, other.command) || len(i.options) != len(other.options) {
+		return false
+	}
+	options := append([]string{}, i.options...)
+	otherOptions := append([]string{}, other.options...)
+	sort.Strings(options)
+	sort.Strings(otherOptions)
+	return equalWords(options, otherOptions)
+}
+
+// merge adds the packages of a following install. The index is updated in front of all packages
+// if any of the installs did, it is cleaned up only if the last install did.
+func (i *packageInstall) merge(other packageInstall) {
+	i.packages = appendMissing(i.packages, other.packages...)
+	if len(i.updates) == 0 {
+		i.updates = other.updates
+	}
+	i.cleanups = other.cleanups
+}
+
+func (i *packageInstall) String() string {
+	words := append(append(append([]string{}, i.command...), i.options...), i.packages...)
+	commands := append(append(append([]string{}, i.updates...), strings.Join(words, " ")), i.cleanups...)
+	return strings.Join(commands, " && ")
+}
+
+func matchesAny(words []string, commands [][]string) bool {
+	for _, cmd := range commands {
+		if equalWords(words, cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalWords(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// runPart is a command of a merged RUN instruction, or merged consecutive package installs.
+type runPart struct {
+	cmd     string
+	install *packageInstall
+}
+
+func (p *runPart) String() string {
+	if p.install != nil {
+		return p.install.String()
+	}
+	return p.cmd
+}
+
+// layer is an instruction of the optimised Dockerfile.
+type layer struct {
+	node     *parser.Node // nil for merged RUN instructions
+	parts    []*runPart
+	features []string
+}
+
+func (l *layer) addFeature(feature string) {
+	if feature == "" {
+		return
+	}
+	for _, known := range l.features {
+		if known == feature {
+			return
+		}
+	}
+	l.features = append(l.features, feature)
+}
+
+func (l *layer) String() string {
+	if l.node != nil {
+		return l.node.Original
+	}
+	if len(l.parts) == 1 {
+		return "RUN " + l.parts[0].String()
+	}
+
+	commands := make([]string, 0, len(l.parts))
+	for _, part := range l.parts {
+		commands = append(commands, "("+part.String()+")")
+	}
+	return "RUN " + strings.Join(commands, " \\\n && ")
+}
+
+// mergeableRun returns the command of a RUN instruction in shell form. Commands with comments
+// or heredocs are not merged, a comment would swallow the commands merged after it.
+func mergeableRun(node *parser.Node) (string, bool) {
+	if node.Value != command.Run || len(node.Flags) > 0 || node.Attributes["json"] || node.Next == nil {
+		return "", false
+	}
+	if strings.Contains(node.Next.Value, "#") || strings.Contains(node.Next.Value, "<<") {
+		return "", false
+	}
+	return node.Next.Value, true
+}
+
+// optimize merges the layers of the collected instructions.
+func optimize(instructions []instruction) []*layer {
+	var (
+		layers  []*layer
+		openRun *layer   // last layer, if further RUN commands can be merged into it
+		install *runPart // last command of openRun, if it is a package install
+	)
+
+	for _, ins := range instructions {
+		node := ins.node
+
+		cmd, ok := mergeableRun(node)
+		if !ok {
+			l := &layer{node: node}
+			l.addFeature(ins.feature)
+			layers = append(layers, l)
+			openRun, install = nil, nil
+			continue
+		}
+
+		if openRun == nil {
+			openRun = &layer{}
+			layers = append(layers, openRun)
+		}
+		openRun.addFeature(ins.feature)
+
+		packages, ok := parsePackageInstall(cmd)
+		switch {
+		case ok && install != nil && install.install.mergeable(packages):
+			install.install.merge(packages)
+		case ok:
+			packages.packages = appendMissing(nil, packages.packages...)
+			install = &runPart{install: &packages}
+			openRun.parts = append(openRun.parts, install)
+		default:
+			install = nil
+			openRun.parts = append(openRun.parts, &runPart{cmd: cmd})
+		}
+	}
+
+	return layers
+}
+
+func appendMissing(slice []string, elements ...string) []string {
+	for _, element := range elements {
+		found := false
+		for _, known := range slice {
+			if known == element {
+				found = true
+				break
+			}
+		}
+		if !found {
+			slice = append(slice, element)
+		}
+	}
+	return slice
+}
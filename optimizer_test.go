@@ -0,0 +1,164 @@
+package pazuzu
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+func TestParsePackageInstall(t *testing.T) {
+	cases := []struct {
+		cmd      string
+		manager  string
+		options  []string
+		packages []string
+	}{
+		{"apt-get update && apt-get install python --yes", "apt-get", []string{"--yes"}, []string{"python"}},
+		{"apt-get update && DEBIAN_FRONTEND=noninteractive apt-get install -y --no-install-recommends curl git && rm -rf /var/lib/apt/lists/*",
+			"apt-get", []string{"-y", "--no-install-recommends"}, []string{"curl", "git"}},
+		{"apk add --no-cache openjdk8=8.121-r0", "apk", []string{"--no-cache"}, []string{"openjdk8=8.121-r0"}},
+		{"yum -y install make gcc && yum clean all", "yum", nil, []string{"make", "gcc"}},
+		{"apt-get install -y curl && apt-get update", "", nil, nil},
+		{"apt-get install -y curl && apt install -y git", "", nil, nil},
+		{"apt-get update", "", nil, nil},
+		{"apt-get install -y openjdk-${version}-jdk", "", nil, nil},
+		{"apt-get install -y curl && curl https://example.org", "", nil, nil},
+		{"apt-get install -y curl; echo done", "", nil, nil},
+		{"apt-get install -y curl # for downloads", "", nil, nil},
+		{"apt-get install -t jessie-backports -y openjdk-8-jdk", "", nil, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.cmd, func(t *testing.T) {
+			install, ok := parsePackageInstall(c.cmd)
+			if ok != (c.manager != "") {
+				t.Fatalf("wrong result: %v", ok)
+			}
+			if ok && (install.manager.name != c.manager || !reflect.DeepEqual(install.options, c.options) ||
+				!reflect.DeepEqual(install.packages, c.packages)) {
+				t.Errorf("wrong install: %s %v %v", install.manager.name, install.options, install.packages)
+			}
+		})
+	}
+}
+
+func TestGenerateOptimized(t *testing.T) {
+	generate := func(t *testing.T, features ...shared.Feature) string {
+		names := []string{}
+		for _, feature := range features {
+			names = append(names, feature.Meta.Name)
+		}
+		pazuzu := Pazuzu{StorageReader: NewMapStorage(features...), Optimize: true}
+		if err := pazuzu.Generate("ubuntu", names); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		return withoutLabels(pazuzu.Dockerfile)
+	}
+
+	t.Run("Merges package installs and RUN instructions", func(t *testing.T) {
+		java := shared.NewFeature_str("java", "", "", nil, "RUN apt-get update && apt-get install -y openjdk-8-jdk", "")
+		python := shared.NewFeature_str("python", "", "", nil, "RUN apt-get update && apt-get install -y python", "")
+		lein := shared.NewFeature_str("lein", "", "", nil, "RUN curl -o /usr/bin/lein https://example.org/lein\nRUN chmod +x /usr/bin/lein", "")
+
+		expected := `FROM ubuntu
+
+# java, python, lein
+RUN (apt-get update && apt-get install -y openjdk-8-jdk python) \
+ && (curl -o /usr/bin/lein https://example.org/lein) \
+ && (chmod +x /usr/bin/lein)
+# image configuration
+CMD /bin/bash
+`
+		if dockerfile := generate(t, java, python, lein); dockerfile != expected {
+			t.Errorf("wrong Dockerfile:\n%s", dockerfile)
+		}
+	})
+
+	t.Run("Keeps installs after the commands in front of them", func(t *testing.T) {
+		curl := shared.NewFeature_str("curl", "", "", nil, "RUN apt-get update && apt-get install -y curl", "")
+		i386 := shared.NewFeature_str("i386", "", "", nil,
+			"RUN dpkg --add-architecture i386\nRUN apt-get update && apt-get install -y libc6:i386", "")
+		tzdata := shared.NewFeature_str("tzdata", "", "", nil,
+			"RUN echo 'tzdata tzdata/Areas select Europe' | debconf-set-selections\nRUN apt-get update && apt-get install -y tzdata", "")
+
+		expected := `RUN (apt-get update && apt-get install -y curl) \
+ && (dpkg --add-architecture i386) \
+ && (apt-get update && apt-get install -y libc6:i386) \
+ && (echo 'tzdata tzdata/Areas select Europe' | debconf-set-selections) \
+ && (apt-get update && apt-get install -y tzdata)
+`
+		if dockerfile := generate(t, curl, i386, tzdata); !strings.Contains(dockerfile, expected) {
+			t.Errorf("wrong Dockerfile:\n%s", dockerfile)
+		}
+	})
+
+	t.Run("Keeps the install options", func(t *testing.T) {
+		curl := shared.NewFeature_str("curl", "", "", nil, "RUN apt-get install -y --no-install-recommends curl", "")
+		git := shared.NewFeature_str("git", "", "", nil, "RUN apt-get install --no-install-recommends -y git", "")
+		vim := shared.NewFeature_str("vim", "", "", nil, "RUN apt-get install -y vim", "")
+
+		expected := `RUN (apt-get install -y --no-install-recommends curl git) \
+ && (apt-get install -y vim)
+`
+		if dockerfile := generate(t, curl, git, vim); !strings.Contains(dockerfile, expected) {
+			t.Errorf("wrong Dockerfile:\n%s", dockerfile)
+		}
+	})
+
+	t.Run("Updates and cleans up the index only as the installs did", func(t *testing.T) {
+		curl := shared.NewFeature_str("curl", "", "", nil, "RUN apt-get update && apt-get install -y curl", "")
+		git := shared.NewFeature_str("git", "", "", nil, "RUN apt-get install -y git && rm -rf /var/lib/apt/lists/*", "")
+		vim := shared.NewFeature_str("vim", "", "", nil, "RUN apt-get update && apt-get install -y -q vim", "")
+
+		expected := "RUN (apt-get update && apt-get install -y curl git && rm -rf /var/lib/apt/lists/*) \\\n" +
+			" && (apt-get update && apt-get install -y -q vim)\n"
+		if dockerfile := generate(t, curl, git, vim); !strings.Contains(dockerfile, expected) {
+			t.Errorf("wrong Dockerfile:\n%s", dockerfile)
+		}
+	})
+
+	t.Run("Keeps the index for installs which can not be merged", func(t *testing.T) {
+		curl := shared.NewFeature_str("curl", "", "", nil, "RUN apt-get update && apt-get install -y curl", "")
+		java := shared.NewFeature_str("java", "", "", nil, "RUN apt-get install -y openjdk-${version}-jdk", "")
+		java.Meta.Params = []shared.FeatureParam{{Name: "version", Default: "8"}}
+
+		expected := "# curl\nRUN apt-get update && apt-get install -y curl\n# java\nARG version=8\nRUN apt-get install -y openjdk-${version}-jdk\n"
+		if dockerfile := generate(t, curl, java); !strings.Contains(dockerfile, expected) {
+			t.Errorf("wrong Dockerfile:\n%s", dockerfile)
+		}
+	})
+
+	t.Run("Keeps RUN instructions with comments apart", func(t *testing.T) {
+		hello := shared.NewFeature_str("hello", "", "", nil, "RUN echo hi # say hi\nRUN echo bye", "")
+		heredoc := shared.NewFeature_str("heredoc", "", "", nil, "RUN cat <<EOF > /etc/motd", "")
+
+		expected := "# hello\nRUN echo hi # say hi\nRUN echo bye\n# heredoc\nRUN cat <<EOF > /etc/motd\n"
+		if dockerfile := generate(t, hello, heredoc); !strings.Contains(dockerfile, expected) {
+			t.Errorf("wrong Dockerfile:\n%s", dockerfile)
+		}
+	})
+
+	t.Run("Keeps build arguments in place", func(t *testing.T) {
+		curl := shared.NewFeature_str("curl", "", "", nil, "RUN install curl", "")
+		node := shared.NewFeature_str("node", "", "", nil, "RUN install node ${version}", "")
+		node.Meta.Params = []shared.FeatureParam{{Name: "version", Default: "16"}}
+
+		expected := "# curl\nRUN install curl\n# node\nARG version=16\nRUN install node ${version}\n"
+		if dockerfile := generate(t, curl, node); !strings.Contains(dockerfile, expected) {
+			t.Errorf("wrong Dockerfile:\n%s", dockerfile)
+		}
+	})
+
+	t.Run("Keeps redeclared build arguments in place", func(t *testing.T) {
+		java := shared.NewFeature_str("java", "", "", nil, "RUN install java ${version}", "")
+		java.Meta.Params = []shared.FeatureParam{{Name: "version", Default: "8"}}
+		node := shared.NewFeature_str("node", "", "", nil, "RUN install node ${version}", "")
+		node.Meta.Params = []shared.FeatureParam{{Name: "version", Default: "16"}}
+
+		dockerfile := generate(t, java, node)
+		if !strings.Contains(dockerfile, "# java\nARG version=8\nRUN install java ${version}\nARG version=\n# node\nARG version=16\nRUN install node ${version}\nARG version=\n") {
+			t.Errorf("wrong Dockerfile:\n%s", dockerfile)
+		}
+	})
+}
@@ -4,10 +4,10 @@ import (
 	"archive/tar"
 	"bytes"
 	"fmt"
-	"github.com/fsouza/go-dockerclient"
 	"gopkg.in/yaml.v2"
 	"io"
 	"io/ioutil"
+	"path/filepath"
 	"time"
 
 	"github.com/zalando-incubator/pazuzu/shared"
@@ -19,22 +19,179 @@ import (
 const (
 	tempDir    = "/tmp/pazuzu/"
 	mountPoint = "/pazuzu/"
+
+	// Dockerfile rewritten for the build options, written to the build context during the build
+	rewrittenDockerfileName = ".pazuzu.Dockerfile"
 )
 
 // Pazuzu defines pazuzu config.
 type Pazuzu struct {
-	StorageReader  storageconnector.StorageReader
-	Dockerfile     []byte
-	TestSpec       []byte
-	testSpec       string
-	DockerEndpoint string
-	docker         *docker.Client
-	files          map[string]string
+	StorageReader storageconnector.StorageReader
+	Dockerfile    []byte
+	TestSpec      []byte
+	Files         map[string]shared.FeatureFile // asset files of the features by path in the build context
+	ContextDir    string                        // directory sent as build context, Dockerfile only if empty
+	Params        map[string]map[string]string  // parameter values by feature name, defaults are used for the others
+	Image         ImageConfig                   // rendered after all the features
+	Custom        []shared.Feature              // custom snippets, they shadow features of StorageReader
+	Lock          Lock                          // features the Dockerfile was generated from
+	Optimize      bool                          // merge layers of the Dockerfile
+	BasePlatform  string                        // family[:version] of the base image, detected from its name if empty
+	Version       string                        // of pazuzu, written into the image labels
+	Docker        DockerConfig                  // daemon or tool to build with
+	BuildEvents   func(BuildEvent)              // receives the build stream, printed to stdout if nil
+	Build         BuildOptions                  // options of DockerBuild
+	testSpec      string
+	builder       Builder
 }
 
 type PazuzuFile struct {
-	Base     string
-	Features []string
+	Base         string
+	Features     []PazuzuFileFeature
+	Image        *ImageConfig  `yaml:"image,omitempty"`
+	Optimize     bool          `yaml:"optimize,omitempty"`      // merge layers of the Dockerfile
+	BasePlatform string        `yaml:"base_platform,omitempty"` // family[:version] of a base image not detected from its name
+	Build        *BuildOptions `yaml:"build,omitempty"`         // defaults of pazuzu build
+}
+
+// ImageConfig is the runtime configuration of the image, set after all the features. CMD and
+// ENTRYPOINT given here take precedence over the ones of the feature snippets, the image runs
+// /bin/bash if neither of them sets any.
+type ImageConfig struct {
+	Entrypoint []string          `yaml:"entrypoint,omitempty"` // exec form
+	Cmd        []string          `yaml:"cmd,omitempty"`        // exec form
+	User       string            `yaml:"user,omitempty"`
+	Workdir    string            `yaml:"workdir,omitempty"`
+	Env        map[string]string `yaml:"env,omitempty"`
+	Labels     map[string]string `yaml:"labels,omitempty"`
+	Expose     []string          `yaml:"expose,omitempty"` // port[/protocol]
+}
+
+// PazuzuFileFeature is a feature of a Pazuzufile, given either as plain name, as name together
+// with values of its parameters, or as custom snippet kept in the Pazuzufile itself or in local
+// files next to it:
+//
+//	features:
+//	  - java
+//	  - name: node
+//	    params: {version: "18"}
+//	  - name: tools
+//	    snippet: RUN apt-get install -y make
+//	    dependencies: [java]
+//	  - name: setup
+//	    snippet_file: build/setup.Dockerfile
+//	    test_snippet_file: build/setup.bats
+type PazuzuFileFeature struct {
+	Name   string            `yaml:"name"`
+	Params map[string]string `yaml:"params,omitempty"`
+
+	Snippet         string   `yaml:"snippet,omitempty"`
+	SnippetFile     string   `yaml:"snippet_file,omitempty"` // relative to the Pazuzufile
+	TestSnippet     string   `yaml:"test_snippet,omitempty"`
+	TestSnippetFile string   `yaml:"test_snippet_file,omitempty"` // relative to the Pazuzufile
+	Dependencies    []string `yaml:"dependencies,omitempty"`
+}
+
+// IsCustom returns true for custom snippets, which are not read from the storage.
+func (f PazuzuFileFeature) IsCustom() bool {
+	return f.Snippet != "" || f.SnippetFile != ""
+}
+
+// customFeature creates the feature of a custom snippet, dir is the directory of the Pazuzufile.
+func (f PazuzuFileFeature) customFeature(dir string) (shared.Feature, error) {
+	if f.Snippet != "" && f.SnippetFile != "" || f.TestSnippet != "" && f.TestSnippetFile != "" {
+		return shared.Feature{}, fmt.Errorf("custom feature '%s' has both inline and file snippets", f.Name)
+	}
+
+	feature := shared.Feature{
+		Meta:        shared.NewMeta_str(f.Name, "", "", f.Dependencies),
+		Snippet:     f.Snippet,
+		TestSnippet: f.TestSnippet,
+	}
+	if f.SnippetFile != "" {
+		content, err := ioutil.ReadFile(filepath.Join(dir, f.SnippetFile))
+		if err != nil {
+			return shared.Feature{}, fmt.Errorf("cannot read snippet of custom feature '%s': %s", f.Name, err)
+		}
+		feature.Snippet = string(content)
+	}
+	if f.TestSnippetFile != "" {
+		content, err := ioutil.ReadFile(filepath.Join(dir, f.TestSnippetFile))
+		if err != nil {
+			return shared.Feature{}, fmt.Errorf("cannot read test snippet of custom feature '%s': %s", f.Name, err)
+		}
+		feature.TestSnippet = string(content)
+	}
+	return feature, nil
+}
+
+func (f *PazuzuFileFeature) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*f = PazuzuFileFeature{Name: name}
+		return nil
+	}
+
+	type plain PazuzuFileFeature
+	var feature plain
+	if err := unmarshal(&feature); err != nil {
+		return err
+	}
+	if feature.Name == "" {
+		return fmt.Errorf("feature without name in Pazuzufile")
+	}
+	*f = PazuzuFileFeature(feature)
+	return nil
+}
+
+// MarshalYAML keeps features without parameters as plain names.
+func (f PazuzuFileFeature) MarshalYAML() (interface{}, error) {
+	if len(f.Params) == 0 && !f.IsCustom() && f.TestSnippet == "" && f.TestSnippetFile == "" && len(f.Dependencies) == 0 {
+		return f.Name, nil
+	}
+
+	type plain PazuzuFileFeature
+	return plain(f), nil
+}
+
+// FeatureNames returns names of all the features of a Pazuzufile.
+func (f PazuzuFile) FeatureNames() []string {
+	names := make([]string, 0, len(f.Features))
+	for _, feature := range f.Features {
+		names = append(names, feature.Name)
+	}
+	return names
+}
+
+// CustomFeatures reads the custom snippets of a Pazuzufile, dir is the directory of the Pazuzufile.
+func (f PazuzuFile) CustomFeatures(dir string) ([]shared.Feature, error) {
+	var features []shared.Feature
+	for _, entry := range f.Features {
+		if !entry.IsCustom() {
+			if entry.TestSnippet != "" || entry.TestSnippetFile != "" || len(entry.Dependencies) > 0 {
+				return nil, fmt.Errorf("feature '%s' has no snippet, custom features require snippet or snippet_file", entry.Name)
+			}
+			continue
+		}
+
+		feature, err := entry.customFeature(dir)
+		if err != nil {
+			return nil, err
+		}
+		features = append(features, feature)
+	}
+	return features, nil
+}
+
+// FeatureParams returns parameter values of the features of a Pazuzufile by feature name.
+func (f PazuzuFile) FeatureParams() map[string]map[string]string {
+	params := map[string]map[string]string{}
+	for _, feature := range f.Features {
+		if len(feature.Params) > 0 {
+			params[feature.Name] = feature.Params
+		}
+	}
+	return params
 }
 
 func Read(reader io.Reader) (PazuzuFile, error) {
@@ -65,206 +222,295 @@ func Write(writer io.Writer, pazuzuFile PazuzuFile) error {
 	return err
 }
 
-// Generate generates Dockfiler and test.spec file base on list of features
+// Generate generates Dockfiler and test.spec file base on list of features.
+// Features which can not be found or resolved are reported all together as FeatureErrors,
+// errors about the storage itself (see storageconnector.IsStorageError) are reported as is.
 func (p *Pazuzu) Generate(baseimage string, features []string) error {
+	storage := p.storage()
+	var errs FeatureErrors
 	var resolvedFeatures []string
 	for _, feature := range features {
-		// TODO: add proper error handling
-		repoFeature, _ := p.StorageReader.GetFeature(feature)
+		repoFeature, err := storage.GetFeature(feature)
+		if storageconnector.IsStorageError(err) {
+			return err
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
 		resolvedFeatures = append(resolvedFeatures, repoFeature.Meta.Name)
 	}
-	// TODO: add proper error handling
-	var featureNamesWithDep []string
-	featureNamesWithDep, featuresMap, _ := p.StorageReader.Resolve(resolvedFeatures...)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	featureNamesWithDep, featuresMap, err := p.resolve(resolvedFeatures)
+	if err != nil {
+		return err
+	}
 	featuresWithDep := make([]shared.Feature, 0, len(featuresMap))
 
 	for _, featureName := range featureNamesWithDep {
 		featuresWithDep = append(featuresWithDep, featuresMap[featureName])
 	}
 
-	err := p.generateDockerfile(baseimage, featuresWithDep)
+	if err := p.checkPlatform(baseimage, featuresWithDep); err != nil {
+		return err
+	}
+	if err := p.generateFiles(featuresWithDep); err != nil {
+		return err
+	}
+
+	buildStage, finalStage := splitStages(resolvedFeatures, featuresWithDep)
+	err = p.generateDockerfile(baseimage, buildStage, finalStage)
 	if err != nil {
 		return err
 	}
 
-	if err := p.generateTestSpec(featuresWithDep); err != nil {
+	if err := p.generateTestSpec(runtimeFeatures(finalStage)); err != nil {
 		return err
 	}
 
+	p.Lock = NewLock(featuresWithDep)
+
 	return nil
 }
 
-// generate in-memory Dockerfile from list of features.
-func (p *Pazuzu) generateDockerfile(baseimage string, features []shared.Feature) error {
+// generateFiles collects asset files of the features. Every feature gets its own folder in the
+// build context, as COPY commands of snippets are rewritten to copy from there. Names and paths
+// escaping that folder are rejected, whichever storage the features come from.
+func (p *Pazuzu) generateFiles(features []shared.Feature) error {
+	p.Files = map[string]shared.FeatureFile{}
+	for _, feature := range features {
+		if !storageconnector.ValidFeatureName(feature.Meta.Name) {
+			return fmt.Errorf("invalid feature name '%s'", feature.Meta.Name)
+		}
+		for path, file := range feature.Files {
+			if !storageconnector.ValidAssetPath(path) {
+				return fmt.Errorf("invalid asset '%s' of feature '%s'", path, feature.Meta.Name)
+			}
+			p.Files[feature.Meta.Name+"/"+path] = file
+		}
+	}
+	return nil
+}
+
+// checkPlatform checks that the features support the platform of the base image. Features are
+// not checked against base images of unknown platform.
+func (p *Pazuzu) checkPlatform(baseimage string, features []shared.Feature) error {
+	platform, ok := DetectPlatform(baseimage)
+	if p.BasePlatform != "" {
+		var err error
+		if platform, err = ParsePlatform(p.BasePlatform); err != nil {
+			return err
+		}
+		ok = true
+	}
+	if !ok {
+		return nil
+	}
+	return checkPlatform(baseimage, platform, features)
+}
+
+// storage returns the storage of all the features, including custom snippets.
+func (p *Pazuzu) storage() storageconnector.StorageReader {
+	if len(p.Custom) == 0 {
+		return p.StorageReader
+	}
+	return storageconnector.NewLayeredStorage(
+		storageconnector.Layer{Name: "Pazuzufile", Storage: storageconnector.NewMemoryStorage(p.Custom...)},
+		storageconnector.Layer{Name: "storage", Storage: p.StorageReader},
+	)
+}
+
+// resolve resolves all the features at once. If that fails, every feature is resolved
+// on its own to report all the failing ones.
+func (p *Pazuzu) resolve(names []string) ([]string, map[string]shared.Feature, error) {
+	resolved, featuresMap, err := p.storage().Resolve(names...)
+	if err == nil || storageconnector.IsStorageError(err) {
+		return resolved, featuresMap, err
+	}
+
+	var errs FeatureErrors
+	for _, name := range names {
+		_, _, nameErr := p.storage().Resolve(name)
+		if storageconnector.IsStorageError(nameErr) {
+			return nil, nil, nameErr
+		}
+		if nameErr != nil {
+			errs = append(errs, nameErr)
+		}
+	}
+	if len(errs) == 0 {
+		errs = append(errs, err)
+	}
+	return nil, nil, errs
+}
+
+// generate in-memory Dockerfile from list of features. Build-only features are installed in a
+// build stage first if there are any, the final stage copies just their artifacts.
+func (p *Pazuzu) generateDockerfile(baseimage string, buildFeatures []shared.Feature, features []shared.Feature) error {
 	writer := NewDockerfileWriter()
+	writer.Optimize = p.Optimize
+
+	if len(buildFeatures) > 0 {
+		err := writer.AppendStage(baseimage, buildStageName)
+		if err != nil {
+			return err
+		}
+
+		for _, feature := range buildFeatures {
+			if err := p.appendFeature(writer, feature); err != nil {
+				return err
+			}
+		}
+	}
 
-	err := writer.AppendRaw(fmt.Sprintf("FROM %s\n", baseimage))
+	err := writer.AppendStage(baseimage, "")
 	if err != nil {
 		return err
 	}
 
 	for _, feature := range features {
-		err = writer.AppendRaw(fmt.Sprintf("# %s\n", feature.Meta.Name))
-		if err != nil {
-			return err
+		if !feature.Meta.BuildOnly {
+			err = p.appendFeature(writer, feature)
+		} else if err = writer.AppendRaw(fmt.Sprintf("# %s (artifacts)\n", feature.Meta.Name)); err == nil {
+			err = writer.AppendArtifacts(feature, buildStageName)
 		}
-
-		err = writer.AppendFeature(feature)
 		if err != nil {
 			return err
 		}
 	}
 
-	err = writer.AppendRaw("CMD /bin/bash\n")
+	image := p.Image
+	image.Labels = mergeLabels(imageLabels(baseimage, p.Version, features), p.Image.Labels)
+	err = writer.AppendImage(image)
 	if err != nil {
 		return err
 	}
 
+	if !writer.cmdSet {
+		err = writer.AppendRaw("CMD /bin/bash\n")
+		if err != nil {
+			return err
+		}
+	}
+
 	p.Dockerfile = writer.Bytes()
 
 	return nil
 }
 
-// DockerBuild builds a docker image based on the generated Dockerfile.
-func (p *Pazuzu) DockerBuild(name string) error {
-	client, err := docker.NewClient(p.DockerEndpoint)
+func (p *Pazuzu) appendFeature(writer *DockerfileWriter, feature shared.Feature) error {
+	err := writer.AppendRaw(fmt.Sprintf("# %s\n", feature.Meta.Name))
 	if err != nil {
-		return fmt.Errorf("Error: %s", err)
 		return err
 	}
 
-	t := time.Now()
-	inputBuf := bytes.NewBuffer(nil)
-	tr := tar.NewWriter(inputBuf)
-	err = tr.WriteHeader(&tar.Header{
-		Name:       "Dockerfile",
-		Size:       int64(len(p.Dockerfile)),
-		ModTime:    t,
-		AccessTime: t,
-		ChangeTime: t,
-	})
+	err = writer.AppendParams(feature, p.Params[feature.Meta.Name])
 	if err != nil {
 		return err
 	}
 
-	_, err = tr.Write(p.Dockerfile)
+	err = writer.AppendFeature(feature)
 	if err != nil {
 		return err
 	}
 
-	err = tr.Close()
-	if err != nil {
-		return err
-	}
+	return writer.ClearParams(feature)
+}
 
-	opts := docker.BuildImageOptions{
-		Name:         name,
-		InputStream:  inputBuf,
-		OutputStream: os.Stdout,
+// imageBuilder sets up the builder of the docker configs, it is kept for later calls.
+func (p *Pazuzu) imageBuilder() (Builder, error) {
+	if p.builder == nil {
+		handle := p.BuildEvents
+		if handle == nil {
+			handle = printBuildEvents(os.Stdout)
+		}
+		builder, err := NewBuilder(p.Docker, handle)
+		if err != nil {
+			return nil, err
+		}
+		p.builder = builder
 	}
+	return p.builder, nil
+}
 
-	err2 := client.BuildImage(opts)
-	if err2 != nil {
-		fmt.Errorf("Error: %s", err2)
+// DockerBuild builds a docker image based on the generated Dockerfile with the builder of the
+// docker configs. If ContextDir is set, the whole directory (including the Dockerfile in it) is
+// the build context, so asset files of the features written there by compose can be copied.
+//
+// The image is tagged with the tags of the build options as well. A failing build returns a
+// BuildError naming the step and the features it comes from, failing tests return an ImageTestError.
+func (p *Pazuzu) DockerBuild(name string) error {
+	builder, err := p.imageBuilder()
+	if err != nil {
 		return err
 	}
 
-	p.testDockerImage(name)
-
-	return nil
-}
-
-func (p *Pazuzu) dockerExec(ID string, cmd string) error {
-	execOpts := docker.CreateExecOptions{
-		Container:    ID,
-		AttachStdin:  false,
-		AttachStdout: true,
-		AttachStderr: true,
-		Cmd: []string{
-			"/bin/bash",
-			"-c",
-			cmd,
-		},
-		Tty: true,
-	}
-	exec, err := p.docker.CreateExec(execOpts)
+	dockerfile, err := p.Build.rewriteDockerfile(p.Dockerfile)
 	if err != nil {
 		return err
 	}
 
-	var buf bytes.Buffer
-	var errBuf bytes.Buffer
-
-	startExecOpts := docker.StartExecOptions{
-		Detach:       false,
-		OutputStream: os.Stdout,
-		ErrorStream:  &errBuf,
-		RawTerminal:  true,
-		Tty:          true,
+	request := BuildRequest{Name: name, Dockerfile: dockerfile, ContextDir: p.ContextDir, Options: p.Build}
+	if p.ContextDir != "" && !bytes.Equal(dockerfile, p.Dockerfile) {
+		// the rewritten Dockerfile is sent along with the Dockerfile of the directory
+		path := filepath.Join(p.ContextDir, rewrittenDockerfileName)
+		if err := ioutil.WriteFile(path, dockerfile, 0644); err != nil {
+			return err
+		}
+		defer os.Remove(path)
+		request.DockerfileName = rewrittenDockerfileName
 	}
 
-	err = p.docker.StartExec(exec.ID, startExecOpts)
-	if err != nil {
+	if err := builder.Build(request); err != nil {
 		return err
 	}
 
-	inspect, err := p.docker.InspectExec(exec.ID)
-	if err != nil {
-		return err
+	for _, tag := range p.Build.Tags {
+		if tag == name {
+			continue
+		}
+		if err := builder.Tag(name, tag); err != nil {
+			return fmt.Errorf("could not tag image %s as %s: %s", name, tag, err)
+		}
 	}
 
-	if inspect.ExitCode > 0 {
-		return fmt.Errorf("exit code %d: %s", inspect.ExitCode, buf.String())
+	if err := p.testDockerImage(name); err != nil {
+		return &ImageTestError{Image: name, Err: err}
 	}
 
 	return nil
 }
 
-func (p *Pazuzu) dockerStart(image string) (*docker.Container, error) {
-	var err error
-	p.docker, err = docker.NewClient(p.DockerEndpoint)
+// dockerfileContext creates a build context with the Dockerfile only.
+func dockerfileContext(dockerfile []byte) (io.Reader, error) {
+	t := time.Now()
+	inputBuf := bytes.NewBuffer(nil)
+	tr := tar.NewWriter(inputBuf)
+	err := tr.WriteHeader(&tar.Header{
+		Name:       "Dockerfile",
+		Size:       int64(len(dockerfile)),
+		ModTime:    t,
+		AccessTime: t,
+		ChangeTime: t,
+	})
 	if err != nil {
 		return nil, err
 	}
-	opts := docker.CreateContainerOptions{
-		Config: &docker.Config{
-			Image: image,
-			Tty:   true,
-			Cmd: []string{
-				"/bin/sh",
-			},
-		},
-		HostConfig: &docker.HostConfig{
-			Binds: []string{
-				tempDir + ":" + mountPoint,
-			},
-		},
-	}
-
-	container, err := p.docker.CreateContainer(opts)
+
+	_, err = tr.Write(dockerfile)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := p.docker.StartContainer(container.ID, nil); err != nil {
+	err = tr.Close()
+	if err != nil {
 		return nil, err
 	}
 
-	return container, nil
-}
-
-func (p *Pazuzu) dockerStop(ID string) error {
-	if err := p.docker.StopContainer(ID, 1); err != nil {
-		return err
-	}
-
-	if err := p.docker.RemoveContainer(docker.RemoveContainerOptions{
-		ID: ID,
-	}); err != nil {
-		return err
-	}
-
-	return nil
+	return inputBuf, nil
 }
 
 func (p *Pazuzu) generateTestSpec(features []shared.Feature) error {
@@ -276,6 +522,19 @@ func (p *Pazuzu) generateTestSpec(features []shared.Feature) error {
 	return nil
 }
 
+// copyTestSpec writes the generated test spec to the mounted directory, or the test spec of the
+// context directory if none was generated.
+func (p *Pazuzu) copyTestSpec() error {
+	spec := p.TestSpec
+	if spec == nil {
+		var err error
+		if spec, err = ioutil.ReadFile(filepath.Join(p.ContextDir, shared.TestSpecFilename)); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(filepath.Join(tempDir, shared.TestSpecFilename), spec, 0644)
+}
+
 func (p *Pazuzu) testDockerImage(image string) error {
 	os.MkdirAll(tempDir, 0777)
 
@@ -296,28 +555,20 @@ func (p *Pazuzu) testDockerImage(image string) error {
 		fmt.Println("Couldn't delete master.zip")
 		return err
 	}
-	if err := exec.Command("cp", shared.TestSpecFilename, tempDir).Run(); err != nil {
+	if err := p.copyTestSpec(); err != nil {
 		fmt.Println("Couldn't copy test.bats file to " + tempDir)
 		return err
 	}
 
-	container, err := p.dockerStart(image)
+	builder, err := p.imageBuilder()
 	if err != nil {
-		fmt.Println("Couldn't start docker container")
-		fmt.Println(err)
 		return err
 	}
 
-	if err := p.dockerExec(
-		container.ID,
+	if err := builder.Run(
+		image,
 		fmt.Sprintf("%sbats-master/install.sh /usr/local && /usr/local/bin/bats -p %s%s", mountPoint, mountPoint, shared.TestSpecFilename)); err != nil {
-		fmt.Println("Couldn't exec test commands on container")
-		fmt.Println(err)
-		return err
-	}
-
-	if err := p.dockerStop(container.ID); err != nil {
-		fmt.Println("Couldn't stop container")
+		fmt.Println("Couldn't run test commands in a container")
 		return err
 	}
 
@@ -2,11 +2,17 @@ package pazuzu
 
 import (
 	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
 
 	"github.com/zalando-incubator/pazuzu/shared"
+	"github.com/zalando-incubator/pazuzu/storageconnector"
 )
 
 type TestStorage struct{}
@@ -36,6 +42,44 @@ func (s *TestStorage) Resolve(names ...string) ([]string, map[string]shared.Feat
 	return []string{}, make(map[string]shared.Feature), nil
 }
 
+// MapStorage is a StorageReader over in-memory features.
+type MapStorage struct {
+	Features    map[string]shared.Feature
+	Unavailable bool
+}
+
+func NewMapStorage(features ...shared.Feature) *MapStorage {
+	s := &MapStorage{Features: map[string]shared.Feature{}}
+	for _, feature := range features {
+		s.Features[feature.Meta.Name] = feature
+	}
+	return s
+}
+
+func (s *MapStorage) GetFeature(name string) (shared.Feature, error) {
+	if s.Unavailable {
+		return shared.Feature{}, &storageconnector.StorageUnavailableError{Storage: "map", Err: errors.New("down")}
+	}
+	feature, ok := s.Features[name]
+	if !ok {
+		return shared.Feature{}, &storageconnector.FeatureNotFoundError{Name: name}
+	}
+	return feature, nil
+}
+
+func (s *MapStorage) GetMeta(name string) (shared.FeatureMeta, error) {
+	feature, err := s.GetFeature(name)
+	return feature.Meta, err
+}
+
+func (s *MapStorage) SearchMeta(name *regexp.Regexp) ([]shared.FeatureMeta, error) {
+	return make([]shared.FeatureMeta, 0), nil
+}
+
+func (s *MapStorage) Resolve(names ...string) ([]string, map[string]shared.Feature, error) {
+	return storageconnector.ResolveDependencies(s, names...)
+}
+
 // Test generating a Dockerfile from a list of features.
 func TestGenerate(t *testing.T) {
 	pazuzu := Pazuzu{
@@ -49,6 +93,337 @@ func TestGenerate(t *testing.T) {
 	}
 }
 
+func TestGenerateErrors(t *testing.T) {
+	storage := NewMapStorage(
+		shared.NewFeature_str("java", "", "", nil, "RUN install java", ""),
+		shared.NewFeature_str("lein", "", "", []string{"java", "curl"}, "RUN install lein", ""),
+		shared.NewFeature_str("maven", "", "", []string{"jdk"}, "RUN install maven", ""),
+	)
+
+	t.Run("Reports all missing features", func(t *testing.T) {
+		pazuzu := Pazuzu{StorageReader: storage}
+		err := pazuzu.Generate("ubuntu", []string{"python", "java", "node"})
+
+		errs, ok := err.(FeatureErrors)
+		if !ok || len(errs) != 2 {
+			t.Fatalf("All missing features should be reported: %v", err)
+		}
+		for _, err := range errs {
+			if !storageconnector.IsFeatureNotFound(err) {
+				t.Errorf("Wrong error type: %v", err)
+			}
+		}
+		if pazuzu.Dockerfile != nil {
+			t.Error("Dockerfile should not be generated")
+		}
+	})
+
+	t.Run("Reports all unresolved dependencies", func(t *testing.T) {
+		pazuzu := Pazuzu{StorageReader: storage}
+		err := pazuzu.Generate("ubuntu", []string{"lein", "java", "maven"})
+
+		errs, ok := err.(FeatureErrors)
+		if !ok || len(errs) != 2 {
+			t.Fatalf("All unresolved features should be reported: %v", err)
+		}
+		for _, err := range errs {
+			if _, ok := err.(*storageconnector.MissingDependencyError); !ok {
+				t.Errorf("Wrong error type: %v", err)
+			}
+		}
+	})
+
+	t.Run("Reports unavailable storage", func(t *testing.T) {
+		pazuzu := Pazuzu{StorageReader: &MapStorage{Unavailable: true}}
+		err := pazuzu.Generate("ubuntu", []string{"java", "lein"})
+		if !storageconnector.IsStorageUnavailable(err) {
+			t.Errorf("Unavailable storage should be reported: %v", err)
+		}
+	})
+
+	t.Run("Rejects names and assets escaping the feature folder", func(t *testing.T) {
+		escaping := shared.NewFeature_str("java", "", "", nil, "COPY java.conf /etc/", "")
+		escaping.Files = map[string]shared.FeatureFile{"../../etc/cron.d/job": {Content: []byte("* * * * * root sh")}}
+		traversing := shared.NewFeature_str("../lein", "", "", nil, "RUN install lein", "")
+
+		for _, feature := range []shared.Feature{escaping, traversing} {
+			pazuzu := Pazuzu{StorageReader: NewMapStorage(feature)}
+			err := pazuzu.Generate("ubuntu", []string{feature.Meta.Name})
+			if err == nil || !strings.HasPrefix(err.Error(), "invalid ") {
+				t.Errorf("%s should be rejected: %v", feature.Meta.Name, err)
+			}
+			if pazuzu.Dockerfile != nil || len(pazuzu.Files) > 0 {
+				t.Errorf("nothing should be generated for %s", feature.Meta.Name)
+			}
+		}
+	})
+}
+
+func TestGenerateParams(t *testing.T) {
+	node := shared.NewFeature_str("node", "", "", nil, "RUN install node ${version}", "")
+	node.Meta.Params = []shared.FeatureParam{{Name: "version", Default: "16"}, {Name: "flags", Default: "--no-docs"}}
+	storage := NewMapStorage(node)
+
+	t.Run("Declares parameters with defaults", func(t *testing.T) {
+		pazuzu := Pazuzu{StorageReader: storage}
+		if err := pazuzu.Generate("ubuntu", []string{"node"}); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if !strings.Contains(string(pazuzu.Dockerfile), "ARG version=16\nARG flags=--no-docs\nRUN install node ${version}\n") {
+			t.Errorf("wrong Dockerfile:\n%s", pazuzu.Dockerfile)
+		}
+	})
+
+	t.Run("Uses values of the Pazuzufile", func(t *testing.T) {
+		pazuzu := Pazuzu{StorageReader: storage, Params: map[string]map[string]string{
+			"node": {"version": "18", "flags": `--prefix "$HOME"`},
+		}}
+		if err := pazuzu.Generate("ubuntu", []string{"node"}); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if !strings.Contains(string(pazuzu.Dockerfile), "ARG version=18\nARG flags=\"--prefix \\\"\\$HOME\\\"\"\n") {
+			t.Errorf("wrong Dockerfile:\n%s", pazuzu.Dockerfile)
+		}
+	})
+
+	t.Run("Clears parameters after the feature", func(t *testing.T) {
+		// uses ${version} without declaring it
+		yarn := shared.NewFeature_str("yarn", "", "", []string{"node"}, "RUN install yarn ${version}", "")
+		pazuzu := Pazuzu{StorageReader: NewMapStorage(node, yarn)}
+		if err := pazuzu.Generate("ubuntu", []string{"yarn"}); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if !strings.Contains(string(pazuzu.Dockerfile), "RUN install node ${version}\nARG version=\nARG flags=\n") ||
+			strings.Index(string(pazuzu.Dockerfile), "ARG version=\n") > strings.Index(string(pazuzu.Dockerfile), "RUN install yarn") {
+			t.Errorf("wrong Dockerfile:\n%s", pazuzu.Dockerfile)
+		}
+	})
+
+	t.Run("Rejects undeclared parameters", func(t *testing.T) {
+		pazuzu := Pazuzu{StorageReader: storage, Params: map[string]map[string]string{"node": {"release": "18"}}}
+		if err := pazuzu.Generate("ubuntu", []string{"node"}); err == nil {
+			t.Error("should fail")
+		}
+	})
+}
+
+func TestGenerateImage(t *testing.T) {
+	storage := NewMapStorage(
+		shared.NewFeature_str("java", "", "", nil, "RUN install java", ""),
+		shared.NewFeature_str("jenkins", "", "", []string{"java"}, "RUN install jenkins\nENTRYPOINT [\"jenkins\"]", ""),
+	)
+
+	t.Run("Runs bash by default", func(t *testing.T) {
+		pazuzu := Pazuzu{StorageReader: storage}
+		if err := pazuzu.Generate("ubuntu", []string{"java"}); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if !strings.HasSuffix(string(pazuzu.Dockerfile), "CMD /bin/bash\n\n") {
+			t.Errorf("wrong Dockerfile:\n%s", pazuzu.Dockerfile)
+		}
+	})
+
+	t.Run("Keeps entrypoint of features", func(t *testing.T) {
+		pazuzu := Pazuzu{StorageReader: storage}
+		if err := pazuzu.Generate("ubuntu", []string{"jenkins"}); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if strings.Contains(string(pazuzu.Dockerfile), "CMD /bin/bash") {
+			t.Errorf("wrong Dockerfile:\n%s", pazuzu.Dockerfile)
+		}
+	})
+
+	t.Run("Renders image configuration after features", func(t *testing.T) {
+		pazuzu := Pazuzu{StorageReader: storage, Image: ImageConfig{
+			Entrypoint: []string{"/usr/bin/tini", "--"},
+			Cmd:        []string{"java", "-jar", "app.jar"},
+			User:       "ci",
+			Workdir:    "/home/ci",
+			Env:        map[string]string{"JAVA_OPTS": "-Xmx1g -Xms1g", "LANG": "C.UTF-8"},
+			Labels:     map[string]string{"team": "ci"},
+			Expose:     []string{"8080", "53/udp"},
+		}}
+		if err := pazuzu.Generate("ubuntu", []string{"jenkins"}); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+
+		expected := `ENTRYPOINT ["jenkins"]
+# image configuration
+
+ENV JAVA_OPTS="-Xmx1g -Xms1g"
+ENV LANG=C.UTF-8
+LABEL team=ci
+EXPOSE 8080
+EXPOSE 53/udp
+WORKDIR /home/ci
+USER ci
+ENTRYPOINT ["/usr/bin/tini","--"]
+CMD ["java","-jar","app.jar"]
+`
+		if !strings.HasSuffix(withoutLabels(pazuzu.Dockerfile), expected) {
+			t.Errorf("wrong Dockerfile:\n%s", pazuzu.Dockerfile)
+		}
+	})
+
+	t.Run("Rejects invalid ports", func(t *testing.T) {
+		pazuzu := Pazuzu{StorageReader: storage, Image: ImageConfig{Expose: []string{"http"}}}
+		if err := pazuzu.Generate("ubuntu", []string{"java"}); err == nil {
+			t.Error("should fail")
+		}
+	})
+}
+
+func TestGenerateCustom(t *testing.T) {
+	storage := NewMapStorage(
+		shared.NewFeature_str("java", "", "", nil, "RUN install java", "@test \"java\" {}"),
+		shared.NewFeature_str("tools", "", "", nil, "RUN install tools", ""),
+	)
+
+	dir, err := ioutil.TempDir("", "pazuzu-custom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "setup.bats"), []byte("@test \"setup\" {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pazuzuFile, err := Read(strings.NewReader(`---
+features:
+  - name: setup
+    snippet: RUN ./setup.sh
+    test_snippet_file: setup.bats
+    dependencies: [java]
+  - name: tools
+    snippet: RUN make tools`))
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	custom, err := pazuzuFile.CustomFeatures(dir)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	pazuzu := Pazuzu{StorageReader: storage, Custom: custom}
+	if err := pazuzu.Generate("ubuntu", pazuzuFile.FeatureNames()); err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	t.Run("Places custom snippets in dependency order", func(t *testing.T) {
+		dockerfile := string(pazuzu.Dockerfile)
+		if !strings.Contains(dockerfile, "RUN install java\n# setup\n\nRUN ./setup.sh\n") {
+			t.Errorf("wrong Dockerfile:\n%s", dockerfile)
+		}
+	})
+
+	t.Run("Custom snippets shadow features of the storage", func(t *testing.T) {
+		dockerfile := string(pazuzu.Dockerfile)
+		if !strings.Contains(dockerfile, "RUN make tools") || strings.Contains(dockerfile, "RUN install tools") {
+			t.Errorf("wrong Dockerfile:\n%s", dockerfile)
+		}
+	})
+
+	t.Run("Adds test snippets", func(t *testing.T) {
+		if !strings.Contains(string(pazuzu.TestSpec), `@test "setup" {}`) {
+			t.Errorf("wrong test spec:\n%s", pazuzu.TestSpec)
+		}
+	})
+
+	t.Run("Writes custom snippets back", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := Write(&buf, pazuzuFile); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		written, err := Read(&buf)
+		if err != nil || !reflect.DeepEqual(written.Features, pazuzuFile.Features) {
+			t.Errorf("custom snippets should be kept: %v, %v", written.Features, err)
+		}
+	})
+
+	t.Run("Reports missing snippet files", func(t *testing.T) {
+		pazuzuFile := PazuzuFile{Features: []PazuzuFileFeature{{Name: "setup", SnippetFile: "missing.Dockerfile"}}}
+		if _, err := pazuzuFile.CustomFeatures(dir); err == nil {
+			t.Error("should fail")
+		}
+	})
+}
+
+func TestGenerateMultiStage(t *testing.T) {
+	curl := shared.NewFeature_str("curl", "", "", nil, "RUN install curl", "@test \"curl\" {}")
+	jdk := shared.NewFeature_str("jdk", "", "", []string{"curl"}, "RUN download jdk\nCMD [\"java\"]", "@test \"jdk\" {}")
+	jdk.Meta.BuildOnly = true
+	jdk.Meta.Artifacts = []string{"/opt/jdk", "/opt/java tools"}
+	lein := shared.NewFeature_str("lein", "", "", []string{"jdk"}, "RUN install lein", "@test \"lein\" {}")
+	storage := NewMapStorage(curl, jdk, lein)
+
+	t.Run("Copies artifacts of build-only features", func(t *testing.T) {
+		pazuzu := Pazuzu{StorageReader: storage}
+		if err := pazuzu.Generate("ubuntu", []string{"lein"}); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+
+		expected := `FROM ubuntu AS pazuzu-build
+
+# curl
+
+RUN install curl
+# jdk
+
+RUN download jdk
+CMD ["java"]
+FROM ubuntu
+
+# jdk (artifacts)
+
+COPY --from=pazuzu-build /opt/jdk /opt/jdk
+COPY --from=pazuzu-build ["/opt/java tools","/opt/java tools"]
+# lein
+
+RUN install lein
+# image configuration
+
+CMD /bin/bash
+
+`
+		if withoutLabels(pazuzu.Dockerfile) != expected {
+			t.Errorf("wrong Dockerfile:\n%s", pazuzu.Dockerfile)
+		}
+	})
+
+	t.Run("Tests runtime features only", func(t *testing.T) {
+		pazuzu := Pazuzu{StorageReader: storage}
+		if err := pazuzu.Generate("ubuntu", []string{"lein"}); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+
+		spec := string(pazuzu.TestSpec)
+		if !strings.Contains(spec, `@test "lein"`) || strings.Contains(spec, `@test "jdk"`) || strings.Contains(spec, `@test "curl"`) {
+			t.Errorf("wrong test spec:\n%s", spec)
+		}
+	})
+
+	t.Run("Keeps requested dependencies of build-only features", func(t *testing.T) {
+		pazuzu := Pazuzu{StorageReader: storage}
+		if err := pazuzu.Generate("ubuntu", []string{"curl", "lein"}); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+
+		final := string(pazuzu.Dockerfile)[strings.Index(string(pazuzu.Dockerfile), "FROM ubuntu\n"):]
+		if !strings.Contains(final, "RUN install curl") {
+			t.Errorf("wrong Dockerfile:\n%s", pazuzu.Dockerfile)
+		}
+	})
+
+	t.Run("Rejects relative artifacts", func(t *testing.T) {
+		jdk.Meta.Artifacts = []string{"jdk"}
+		pazuzu := Pazuzu{StorageReader: NewMapStorage(curl, jdk)}
+		if err := pazuzu.Generate("ubuntu", []string{"jdk"}); err == nil {
+			t.Error("should fail")
+		}
+	})
+}
+
 func TestRead(t *testing.T) {
 	bufferedReader := strings.NewReader(`---
 base: ubuntuCommon
@@ -66,12 +441,85 @@ features:
 	if strings.Compare(pazuzuFile.Base, "ubuntuCommon") != 0 {
 		t.Errorf("wrong base: %s", pazuzuFile.Base)
 	}
+	if pazuzuFile.Image != nil {
+		t.Errorf("image should not be set: %v", pazuzuFile.Image)
+	}
+}
+
+func TestReadImage(t *testing.T) {
+	pazuzuFile, err := Read(strings.NewReader(`---
+base: ubuntu
+features: [java]
+image:
+  entrypoint: [/usr/bin/tini, --]
+  user: ci
+  env: {LANG: C.UTF-8}
+  expose: [8080]`))
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	expected := &ImageConfig{
+		Entrypoint: []string{"/usr/bin/tini", "--"},
+		User:       "ci",
+		Env:        map[string]string{"LANG": "C.UTF-8"},
+		Expose:     []string{"8080"},
+	}
+	if !reflect.DeepEqual(pazuzuFile.Image, expected) {
+		t.Errorf("wrong image: %v", pazuzuFile.Image)
+	}
+}
+
+func TestReadFeatureParams(t *testing.T) {
+	t.Run("Reads plain and parameterised features", func(t *testing.T) {
+		pazuzuFile, err := Read(strings.NewReader(`---
+base: ubuntu
+features:
+  - java
+  - name: node
+    params: {version: 18}
+  - {name: lein}`))
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+
+		if names := pazuzuFile.FeatureNames(); !reflect.DeepEqual(names, []string{"java", "node", "lein"}) {
+			t.Errorf("wrong features: %v", names)
+		}
+		expected := map[string]map[string]string{"node": {"version": "18"}}
+		if params := pazuzuFile.FeatureParams(); !reflect.DeepEqual(params, expected) {
+			t.Errorf("wrong params: %v", params)
+		}
+	})
+
+	t.Run("Rejects features without name", func(t *testing.T) {
+		_, err := Read(strings.NewReader("features:\n  - params: {version: 18}\n"))
+		if err == nil {
+			t.Error("should fail")
+		}
+	})
+
+	t.Run("Writes features without params as plain names", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := Write(&buf, PazuzuFile{Base: "ubuntu", Features: []PazuzuFileFeature{
+			{Name: "java"},
+			{Name: "node", Params: map[string]string{"version": "18"}},
+		}})
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+
+		expected := "base: ubuntu\nfeatures:\n- java\n- name: node\n  params:\n    version: \"18\"\n"
+		if buf.String() != expected {
+			t.Errorf("wrong Pazuzufile:\n%s", buf.String())
+		}
+	})
 }
 
 func TestWrite(t *testing.T) {
 	pazuzuFile := PazuzuFile{
 		Base:     "ubuntuCommon",
-		Features: []string{"java8", "anotherFeature", "oneMoreFeature"},
+		Features: []PazuzuFileFeature{{Name: "java8"}, {Name: "anotherFeature"}, {Name: "oneMoreFeature"}},
 	}
 
 	b := []byte{}
@@ -86,13 +534,110 @@ func TestWrite(t *testing.T) {
 // Test building a generated Dockerfile.
 func TestDockerBuild(t *testing.T) {
 	pazuzu := Pazuzu{
-		DockerEndpoint: "unix:///var/run/docker.sock",
+		Docker: DockerConfig{Endpoint: "unix:///var/run/docker.sock"},
 		Dockerfile: []byte(`FROM ubuntu:latest
 RUN apt-get update && apt-get install python --yes`),
 		testSpec: "test_spec.json",
 	}
-	err := pazuzu.DockerBuild("test")
+	client, err := NewDockerClient(pazuzu.Docker)
+	if err != nil || client.Ping() != nil {
+		t.Skip("no Docker daemon available")
+	}
+
+	err = pazuzu.DockerBuild("test")
 	if err != nil {
 		t.Errorf("should not fail: %s", err)
 	}
 }
+
+func TestDockerBuildTestSpec(t *testing.T) {
+	contextDir, err := ioutil.TempDir("", "pazuzu-context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(contextDir)
+	if err := ioutil.WriteFile(filepath.Join(contextDir, shared.TestSpecFilename), []byte("@test \"java\" {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	workDir, err := ioutil.TempDir("", "pazuzu-workdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+	previous, _ := os.Getwd()
+	if err := os.Chdir(workDir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(previous)
+
+	_, restoreWget := fakeTool(t, "wget", `touch "$3"`)
+	defer restoreWget()
+	_, restoreUnzip := fakeTool(t, "unzip", "")
+	defer restoreUnzip()
+	_, restorePodman := fakeTool(t, BuilderPodman, `if [ "$1" = run ]; then cat `+tempDir+shared.TestSpecFilename+`; fi`)
+	defer restorePodman()
+
+	var output []string
+	pazuzu := Pazuzu{
+		Docker:      DockerConfig{Builder: BuilderPodman},
+		Dockerfile:  []byte("FROM ubuntu"),
+		ContextDir:  contextDir,
+		BuildEvents: func(event BuildEvent) { output = append(output, event.Message) },
+	}
+	if err := pazuzu.DockerBuild("ci-image"); err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+	if len(output) == 0 || output[len(output)-1] != "@test \"java\" {}" {
+		t.Errorf("should test with the test spec of the context directory: %v", output)
+	}
+}
+
+func TestGeneratePlatform(t *testing.T) {
+	curl := shared.NewFeature_str("curl", "", "", nil, "RUN install curl", "")
+	java := shared.NewFeature_str("java", "", "", []string{"curl"}, "RUN install java", "")
+	java.Meta.Platforms = []string{"ubuntu:16.04", "debian"}
+	apk := shared.NewFeature_str("apk", "", "", nil, "RUN apk add git", "")
+	apk.Meta.Platforms = []string{"alpine"}
+	storage := NewMapStorage(curl, java, apk)
+
+	t.Run("Accepts supported base images", func(t *testing.T) {
+		for _, base := range []string{"ubuntu:16.04", "ubuntu:xenial", "debian:stretch-slim"} {
+			pazuzu := Pazuzu{StorageReader: storage}
+			if err := pazuzu.Generate(base, []string{"java"}); err != nil {
+				t.Errorf("should not fail for %s: %s", base, err)
+			}
+		}
+	})
+
+	t.Run("Fails for incompatible features", func(t *testing.T) {
+		pazuzu := Pazuzu{StorageReader: storage}
+		err := pazuzu.Generate("ubuntu:14.04", []string{"java", "apk"})
+		errs, ok := err.(FeatureErrors)
+		if !ok || len(errs) != 2 {
+			t.Fatalf("should fail for java and apk: %v", err)
+		}
+		if errs[0].Error() != "feature 'java' supports ubuntu:16.04, debian only, base image ubuntu:14.04 is ubuntu:14.04" {
+			t.Errorf("wrong error: %s", errs[0])
+		}
+	})
+
+	t.Run("Does not check unknown base images", func(t *testing.T) {
+		pazuzu := Pazuzu{StorageReader: storage}
+		if err := pazuzu.Generate("example.org/base", []string{"java", "apk"}); err != nil {
+			t.Errorf("should not fail: %s", err)
+		}
+	})
+
+	t.Run("Checks the platform set for the base image", func(t *testing.T) {
+		pazuzu := Pazuzu{StorageReader: storage, BasePlatform: "alpine:3.6"}
+		if err := pazuzu.Generate("example.org/base", []string{"apk"}); err != nil {
+			t.Errorf("should not fail: %s", err)
+		}
+
+		pazuzu = Pazuzu{StorageReader: storage, BasePlatform: "alpine"}
+		if err := pazuzu.Generate("example.org/base", []string{"java"}); err == nil {
+			t.Error("should fail for java")
+		}
+	})
+}
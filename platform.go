@@ -0,0 +1,139 @@
+package pazuzu
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+// Platform is the OS family and version of a base image, such as ubuntu 16.04. Version
+// is empty if it is not known.
+type Platform struct {
+	Family  string
+	Version string
+}
+
+func (p Platform) String() string {
+	if p.Version == "" {
+		return p.Family
+	}
+	return p.Family + ":" + p.Version
+}
+
+// IncompatibleFeatureError is returned when a feature does not support the platform of the base image.
+type IncompatibleFeatureError struct {
+	Name      string
+	Platforms []string // supported by the feature
+	Base      string
+	Platform  Platform
+}
+
+func (e *IncompatibleFeatureError) Error() string {
+	message := fmt.Sprintf("feature '%s' supports %s only, base image %s is %s",
+		e.Name, strings.Join(e.Platforms, ", "), e.Base, e.Platform)
+	if e.Platform.Version == "" {
+		message += " of unknown version, set the platform of the Pazuzufile"
+	}
+	return message
+}
+
+var (
+	platformRegexp = regexp.MustCompile(`^([a-z][a-z0-9]*)(:([a-z0-9][a-z0-9._]*))?$`)
+
+	// OS families detected from the name of the base image
+	knownFamilies = map[string]bool{
+		"ubuntu": true, "debian": true, "alpine": true, "centos": true, "fedora": true,
+		"amazonlinux": true, "opensuse": true, "archlinux": true,
+	}
+
+	// release names are used as well as version numbers
+	releaseVersions = map[string]map[string]string{
+		"debian": {"wheezy": "7", "jessie": "8", "stretch": "9", "buster": "10", "bullseye": "11", "bookworm": "12"},
+		"ubuntu": {"trusty": "14.04", "xenial": "16.04", "bionic": "18.04", "focal": "20.04", "jammy": "22.04"},
+	}
+)
+
+// ParsePlatform parses platforms given as family[:version], e.g. ubuntu:16.04 or debian:stretch.
+func ParsePlatform(value string) (Platform, error) {
+	match := platformRegexp.FindStringSubmatch(strings.ToLower(strings.TrimSpace(value)))
+	if match == nil {
+		return Platform{}, fmt.Errorf("invalid platform '%s', expected family[:version]", value)
+	}
+	return normalizePlatform(Platform{Family: match[1], Version: match[3]}), nil
+}
+
+func normalizePlatform(p Platform) Platform {
+	if version, ok := releaseVersions[p.Family][p.Version]; ok {
+		p.Version = version
+	}
+	if p.Version == "latest" {
+		p.Version = ""
+	}
+	return p
+}
+
+// DetectPlatform guesses the platform of a base image from its name, such as ubuntu:14.04 or
+// registry.example.org/library/debian:stretch-slim. ok is false for images of unknown families.
+func DetectPlatform(image string) (platform Platform, ok bool) {
+	image = strings.SplitN(image, "@", 2)[0]
+	name, tag := image, ""
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name, tag = image[:i], image[i+1:]
+	}
+	family := strings.ToLower(name[strings.LastIndex(name, "/")+1:])
+	if !knownFamilies[family] {
+		return Platform{}, false
+	}
+
+	version := strings.SplitN(strings.ToLower(tag), "-", 2)[0]
+	return normalizePlatform(Platform{Family: family, Version: version}), true
+}
+
+// SupportsPlatform checks the platforms declared by a feature. Features which declare no platforms
+// support any. A declared version matches the version of the platform and all versions below it,
+// e.g. alpine:3 matches alpine:3.6. Declared versions do not match platforms of unknown version.
+func SupportsPlatform(meta shared.FeatureMeta, platform Platform) (bool, error) {
+	if len(meta.Platforms) == 0 {
+		return true, nil
+	}
+
+	for _, value := range meta.Platforms {
+		declared, err := ParsePlatform(value)
+		if err != nil {
+			return false, fmt.Errorf("feature '%s': %s", meta.Name, err)
+		}
+		if declared.Family != platform.Family {
+			continue
+		}
+		if declared.Version == "" || declared.Version == platform.Version ||
+			strings.HasPrefix(platform.Version, declared.Version+".") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkPlatform reports all the features which do not support the platform.
+func checkPlatform(base string, platform Platform, features []shared.Feature) error {
+	var errs FeatureErrors
+	for _, feature := range features {
+		ok, err := SupportsPlatform(feature.Meta, platform)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			errs = append(errs, &IncompatibleFeatureError{
+				Name:      feature.Meta.Name,
+				Platforms: feature.Meta.Platforms,
+				Base:      base,
+				Platform:  platform,
+			})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
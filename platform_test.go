@@ -0,0 +1,93 @@
+package pazuzu
+
+import (
+	"testing"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+func TestDetectPlatform(t *testing.T) {
+	t.Run("Detects family and version from the image name", func(t *testing.T) {
+		examples := map[string]Platform{
+			"ubuntu":                          {"ubuntu", ""},
+			"ubuntu:latest":                   {"ubuntu", ""},
+			"ubuntu:16.04":                    {"ubuntu", "16.04"},
+			"ubuntu:xenial":                   {"ubuntu", "16.04"},
+			"debian:stretch-slim":             {"debian", "9"},
+			"alpine:3.6@sha256:0123":          {"alpine", "3.6"},
+			"localhost:5000/library/centos:7": {"centos", "7"},
+			"registry.example.org/mirror/Debian:jessie": {"debian", "8"},
+		}
+		for image, expected := range examples {
+			platform, ok := DetectPlatform(image)
+			if !ok || platform != expected {
+				t.Errorf("wrong platform of %s: %v", image, platform)
+			}
+		}
+	})
+
+	t.Run("Does not detect unknown families", func(t *testing.T) {
+		for _, image := range []string{"", "openjdk:8", "localhost:5000/base", "example.org/ubuntu-java:16.04"} {
+			if platform, ok := DetectPlatform(image); ok {
+				t.Errorf("should not detect %s: %v", image, platform)
+			}
+		}
+	})
+}
+
+func TestParsePlatform(t *testing.T) {
+	t.Run("Parses family and version", func(t *testing.T) {
+		examples := map[string]Platform{
+			"gentoo":        {"gentoo", ""},
+			" Ubuntu:16.04": {"ubuntu", "16.04"},
+			"debian:buster": {"debian", "10"},
+		}
+		for value, expected := range examples {
+			platform, err := ParsePlatform(value)
+			if err != nil || platform != expected {
+				t.Errorf("wrong platform of %s: %v, %v", value, platform, err)
+			}
+		}
+	})
+
+	t.Run("Fails for invalid platforms", func(t *testing.T) {
+		for _, value := range []string{"", "ubuntu:", "example.org/ubuntu", ":16.04"} {
+			if _, err := ParsePlatform(value); err == nil {
+				t.Errorf("should fail for '%s'", value)
+			}
+		}
+	})
+}
+
+func TestSupportsPlatform(t *testing.T) {
+	meta := shared.FeatureMeta{Name: "java", Platforms: []string{"debian", "ubuntu:16.04", "alpine:3"}}
+	examples := map[Platform]bool{
+		{"debian", ""}:      true,
+		{"debian", "9"}:     true,
+		{"ubuntu", "16.04"}: true,
+		{"ubuntu", "14.04"}: false,
+		{"ubuntu", ""}:      false,
+		{"alpine", "3.6"}:   true,
+		{"alpine", "31"}:    false,
+		{"centos", "7"}:     false,
+	}
+	for platform, expected := range examples {
+		supported, err := SupportsPlatform(meta, platform)
+		if err != nil || supported != expected {
+			t.Errorf("wrong result for %s: %v, %v", platform, supported, err)
+		}
+	}
+
+	t.Run("Supports any platform without declared platforms", func(t *testing.T) {
+		supported, err := SupportsPlatform(shared.FeatureMeta{Name: "curl"}, Platform{"centos", "7"})
+		if err != nil || !supported {
+			t.Errorf("should support centos: %v", err)
+		}
+	})
+
+	t.Run("Fails for invalid declared platforms", func(t *testing.T) {
+		if _, err := SupportsPlatform(shared.FeatureMeta{Name: "curl", Platforms: []string{"ubuntu 16.04"}}, Platform{"ubuntu", ""}); err == nil {
+			t.Error("should fail")
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package pazuzu
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DigestsFilename is the file next to the Pazuzufile recording the digests of pushed images.
+const DigestsFilename = "image.digests"
+
+// PushError is returned when an image fails to be pushed.
+type PushError struct {
+	Image   string
+	Message string
+}
+
+func (e *PushError) Error() string {
+	return fmt.Sprintf("could not push image %s: %s", e.Image, e.Message)
+}
+
+// pushMessage is a message of the JSON push stream.
+type pushMessage struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	Progress    string `json:"progress"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+	Aux struct {
+		Digest string `json:"Digest"`
+	} `json:"aux"`
+}
+
+// readPushStream reads the JSON push stream of the Docker daemon to its end and passes its status
+// lines to handle as output. It returns the digest of the pushed image or a PushError for the first
+// error of the stream.
+func readPushStream(image string, reader io.Reader, handle func(BuildEvent)) (string, error) {
+	defer drain(reader)
+
+	var (
+		decoder = json.NewDecoder(reader)
+		digest  string
+		pushErr *PushError
+	)
+	for {
+		var message pushMessage
+		if err := decoder.Decode(&message); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", fmt.Errorf("could not read the push stream: %s", err)
+		}
+
+		// progress updates of pushed layers are left out
+		if message.Status != "" && message.Progress == "" {
+			line := message.Status
+			if message.ID != "" {
+				line = message.ID + ": " + line
+			}
+			handle(BuildEvent{Type: BuildOutput, Message: line})
+		}
+		if message.Aux.Digest != "" {
+			digest = message.Aux.Digest
+		}
+		if message.Error != "" || message.ErrorDetail.Message != "" {
+			text := message.ErrorDetail.Message
+			if text == "" {
+				text = message.Error
+			}
+			handle(BuildEvent{Type: BuildFailure, Message: text})
+			if pushErr == nil {
+				pushErr = &PushError{Image: image, Message: text}
+			}
+		}
+	}
+
+	if pushErr != nil {
+		return "", pushErr
+	}
+	if digest == "" {
+		return "", &PushError{Image: image, Message: "no digest in the response of the Docker daemon"}
+	}
+	return digest, nil
+}
+
+// DockerPush pushes a local image to its registry with the builder of the docker configs. It
+// returns the digest of the pushed image.
+func (p *Pazuzu) DockerPush(name string) (string, error) {
+	builder, err := p.imageBuilder()
+	if err != nil {
+		return "", err
+	}
+	return builder.Push(name)
+}
+
+// RecordDigests adds the digests of pushed images to the digests file in dir, one line of
+// name@digest per image. Earlier digests of the same images are replaced.
+func RecordDigests(dir string, digests map[string]string) error {
+	path := filepath.Join(dir, DigestsFilename)
+	recorded := map[string]string{}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if i := strings.LastIndex(line, "@"); i > 0 {
+			recorded[line[:i]] = line[i+1:]
+		}
+	}
+	for name, digest := range digests {
+		recorded[name] = digest
+	}
+
+	lines := make([]string, 0, len(recorded))
+	for name, digest := range recorded {
+		lines = append(lines, name+"@"+digest)
+	}
+	sort.Strings(lines)
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
@@ -0,0 +1,178 @@
+package pazuzu
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestReadPushStream(t *testing.T) {
+	t.Run("Returns the digest of the pushed image", func(t *testing.T) {
+		stream := `{"status":"The push refers to a repository [localhost:5000/java]"}
+{"status":"Preparing","progressDetail":{},"id":"5f70bf18a086"}
+{"status":"Pushing","progressDetail":{"current":512,"total":1024},"progress":"[=====>     ]","id":"5f70bf18a086"}
+{"status":"Pushed","progressDetail":{},"id":"5f70bf18a086"}
+{"status":"latest: digest: sha256:4f0a size: 528"}
+{"progressDetail":{},"aux":{"Tag":"latest","Digest":"sha256:4f0a","Size":528}}
+`
+		var lines []string
+		digest, err := readPushStream("localhost:5000/java", strings.NewReader(stream), func(event BuildEvent) {
+			lines = append(lines, event.Message)
+		})
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if digest != "sha256:4f0a" {
+			t.Errorf("digest should be sha256:4f0a, was %s", digest)
+		}
+		expected := []string{
+			"The push refers to a repository [localhost:5000/java]",
+			"5f70bf18a086: Preparing",
+			"5f70bf18a086: Pushed",
+			"latest: digest: sha256:4f0a size: 528",
+		}
+		if !reflect.DeepEqual(lines, expected) {
+			t.Errorf("output should be %v, was %v", expected, lines)
+		}
+	})
+
+	t.Run("Returns errors of the stream", func(t *testing.T) {
+		stream := `{"status":"The push refers to a repository [localhost:5000/java]"}
+{"errorDetail":{"message":"unauthorized: authentication required"},"error":"unauthorized: authentication required"}
+`
+		_, err := readPushStream("localhost:5000/java", strings.NewReader(stream), func(BuildEvent) {})
+		pushErr, ok := err.(*PushError)
+		if !ok {
+			t.Fatalf("should fail with a PushError, was %v", err)
+		}
+		expected := "could not push image localhost:5000/java: unauthorized: authentication required"
+		if pushErr.Error() != expected {
+			t.Errorf("error should be '%s', was '%s'", expected, pushErr)
+		}
+	})
+}
+
+func TestDockerPush(t *testing.T) {
+	defer writeDockerConfig(t, `{"auths": {"localhost:5000": {"auth": "`+
+		base64.StdEncoding.EncodeToString([]byte("user:secret"))+`"}}}`, nil)()
+
+	var (
+		path string
+		auth docker.AuthConfiguration
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path + "?" + r.URL.RawQuery
+		header, _ := base64.URLEncoding.DecodeString(r.Header.Get("X-Registry-Auth"))
+		json.Unmarshal(header, &auth)
+		fmt.Fprintln(w, `{"status":"Pushed","id":"5f70bf18a086"}`)
+		fmt.Fprintln(w, `{"aux":{"Tag":"8","Digest":"sha256:4f0a","Size":528}}`)
+	}))
+	defer server.Close()
+
+	p := Pazuzu{Docker: DockerConfig{Endpoint: server.URL}, BuildEvents: func(BuildEvent) {}}
+	digest, err := p.DockerPush("localhost:5000/java:8")
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+	if digest != "sha256:4f0a" {
+		t.Errorf("digest should be sha256:4f0a, was %s", digest)
+	}
+	if path != "/images/localhost:5000/java/push?tag=8" {
+		t.Errorf("should push localhost:5000/java with tag 8, was %s", path)
+	}
+	if auth.Username != "user" || auth.Password != "secret" {
+		t.Errorf("should push with the credentials of localhost:5000, was %v", auth)
+	}
+}
+
+func TestRecordDigests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pazuzu-digests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := RecordDigests(dir, map[string]string{"java:8": "sha256:1", "java:latest": "sha256:1"}); err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+	if err := RecordDigests(dir, map[string]string{"java:8": "sha256:2"}); err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, DigestsFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "java:8@sha256:2\njava:latest@sha256:1\n"
+	if string(content) != expected {
+		t.Errorf("digests should be %q, was %q", expected, content)
+	}
+}
+
+// TestDockerPushRegistry pushes an image to a registry:2 container of the local Docker daemon.
+func TestDockerPushRegistry(t *testing.T) {
+	p := Pazuzu{Docker: DockerConfig{Endpoint: "unix:///var/run/docker.sock"}, BuildEvents: func(BuildEvent) {}}
+	client, err := NewDockerClient(p.Docker)
+	if err != nil || client.Ping() != nil {
+		t.Skip("no Docker daemon available")
+	}
+	defer writeDockerConfig(t, `{}`, nil)()
+
+	if _, err := client.InspectImage("registry:2"); err != nil {
+		err = client.PullImage(docker.PullImageOptions{Repository: "registry", Tag: "2"}, docker.AuthConfiguration{})
+		if err != nil {
+			t.Skipf("registry:2 not available: %s", err)
+		}
+	}
+
+	container, err := client.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{Image: "registry:2", ExposedPorts: map[docker.Port]struct{}{"5000/tcp": {}}},
+		HostConfig: &docker.HostConfig{
+			PortBindings: map[docker.Port][]docker.PortBinding{"5000/tcp": {{HostIP: "127.0.0.1"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, Force: true})
+	if err := client.StartContainer(container.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	container, err = client.InspectContainer(container.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := container.NetworkSettings.Ports["5000/tcp"][0].HostPort
+
+	name := "localhost:" + port + "/pazuzu-test:latest"
+	if err := client.TagImage("registry:2", docker.TagImageOptions{Repo: "localhost:" + port + "/pazuzu-test", Tag: "latest"}); err != nil {
+		t.Fatal(err)
+	}
+	defer client.RemoveImage(name)
+
+	// the registry takes a moment to start
+	var digest string
+	for i := 0; i < 10; i++ {
+		if digest, err = p.DockerPush(name); err == nil {
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+	if !strings.HasPrefix(digest, "sha256:") {
+		t.Errorf("digest should be sha256, was %s", digest)
+	}
+}
@@ -0,0 +1,161 @@
+package pazuzu
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// Environment variable with the configuration directory of the Docker client
+	DockerConfigEnvVar = "DOCKER_CONFIG"
+
+	// registry of images without registry host, as named in the configuration of the Docker client
+	dockerHubHost      = "index.docker.io"
+	dockerHubServerURL = "https://index.docker.io/v1/"
+)
+
+// dockerConfigFile is the part of config.json of the Docker client naming the credentials.
+type dockerConfigFile struct {
+	Auths       map[string]dockerAuth `json:"auths"`
+	CredsStore  string                `json:"credsStore"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+}
+
+type dockerAuth struct {
+	Auth          string `json:"auth"` // base64 of username:password
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// credentialHelperOutput is the result of docker-credential-<helper> get.
+type credentialHelperOutput struct {
+	Username string
+	Secret   string
+}
+
+// registryHost returns the registry of an image like the Docker client, images without registry
+// host are on the Docker Hub.
+func registryHost(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 1 || !strings.ContainsAny(parts[0], ".:") && parts[0] != "localhost" {
+		return dockerHubHost
+	}
+	if parts[0] == "docker.io" {
+		return dockerHubHost
+	}
+	return parts[0]
+}
+
+// normalizeRegistry strips scheme and path of registries named in config.json.
+func normalizeRegistry(registry string) string {
+	if i := strings.Index(registry, "://"); i >= 0 {
+		registry = registry[i+len("://"):]
+	}
+	registry = strings.SplitN(registry, "/", 2)[0]
+	if registry == "docker.io" {
+		return dockerHubHost
+	}
+	return registry
+}
+
+// RegistryAuth returns the credentials of the Docker client for the registry of an image, read
+// from config.json in DOCKER_CONFIG or ~/.docker. Credential helpers of the registry take
+// precedence over its auths, the credentials store is asked for registries without auths.
+// Credentials are empty if none are configured.
+func RegistryAuth(image string) (docker.AuthConfiguration, error) {
+	host := registryHost(image)
+	serverAddress := host
+	if host == dockerHubHost {
+		serverAddress = dockerHubServerURL
+	}
+
+	configDir := os.Getenv(DockerConfigEnvVar)
+	if configDir == "" {
+		configDir = filepath.Join(UserHomeDir(), DefaultDockerCertPathPart)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(configDir, "config.json"))
+	if os.IsNotExist(err) {
+		return docker.AuthConfiguration{}, nil
+	}
+	if err != nil {
+		return docker.AuthConfiguration{}, err
+	}
+
+	var config dockerConfigFile
+	if err := json.Unmarshal(content, &config); err != nil {
+		return docker.AuthConfiguration{}, fmt.Errorf("cannot read %s: %s", filepath.Join(configDir, "config.json"), err)
+	}
+
+	for registry, helper := range config.CredHelpers {
+		if normalizeRegistry(registry) == host {
+			return helperAuth(helper, serverAddress)
+		}
+	}
+	for registry, auth := range config.Auths {
+		if normalizeRegistry(registry) == host && (auth.Auth != "" || auth.Username != "" || auth.IdentityToken != "") {
+			return decodeAuth(auth, serverAddress)
+		}
+	}
+	if config.CredsStore != "" {
+		return helperAuth(config.CredsStore, serverAddress)
+	}
+	return docker.AuthConfiguration{}, nil
+}
+
+func decodeAuth(auth dockerAuth, serverAddress string) (docker.AuthConfiguration, error) {
+	if auth.IdentityToken != "" {
+		return docker.AuthConfiguration{}, fmt.Errorf("identity tokens are not supported for %s, use username and password", serverAddress)
+	}
+
+	result := docker.AuthConfiguration{Username: auth.Username, Password: auth.Password, ServerAddress: serverAddress}
+	if auth.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return docker.AuthConfiguration{}, fmt.Errorf("invalid auth of %s: %s", serverAddress, err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return docker.AuthConfiguration{}, fmt.Errorf("invalid auth of %s, expected username:password", serverAddress)
+		}
+		result.Username, result.Password = parts[0], parts[1]
+	}
+	return result, nil
+}
+
+// helperAuth asks the credential helper docker-credential-<helper> for the credentials of a
+// registry. Registries unknown to the helper are accessed without credentials.
+func helperAuth(helper string, serverAddress string) (docker.AuthConfiguration, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverAddress)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		message := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(message, "credentials not found") {
+			return docker.AuthConfiguration{}, nil
+		}
+		return docker.AuthConfiguration{}, fmt.Errorf("credential helper %s failed for %s: %s %s", helper, serverAddress, err, message)
+	}
+
+	var output credentialHelperOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return docker.AuthConfiguration{}, fmt.Errorf("invalid output of credential helper %s: %s", helper, err)
+	}
+	// helpers return identity tokens with this username
+	if output.Username == "<token>" {
+		return docker.AuthConfiguration{}, fmt.Errorf("identity tokens are not supported for %s, use username and password", serverAddress)
+	}
+	return docker.AuthConfiguration{Username: output.Username, Password: output.Secret, ServerAddress: serverAddress}, nil
+}
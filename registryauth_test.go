@@ -0,0 +1,146 @@
+package pazuzu
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// writeDockerConfig writes config.json and credential helpers printing the given outputs
+// into a temporary directory, used as DOCKER_CONFIG and PATH.
+func writeDockerConfig(t *testing.T, config string, helpers map[string]string) func() {
+	dir, err := ioutil.TempDir("", "pazuzu-docker-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, output := range helpers {
+		script := "#!/bin/sh\ncat > /dev/null\necho '" + output + "'\n"
+		if strings.HasPrefix(output, "credentials not found") {
+			script += "exit 1\n"
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "docker-credential-"+name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restore := setEnv(t, map[string]string{DockerConfigEnvVar: dir, "PATH": dir + ":" + os.Getenv("PATH")})
+	return func() {
+		restore()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	for image, expected := range map[string]string{
+		"ubuntu":                         dockerHubHost,
+		"zalando/pazuzu:1.0":             dockerHubHost,
+		"docker.io/zalando/pazuzu":       dockerHubHost,
+		"localhost/pazuzu":               "localhost",
+		"localhost:5000/pazuzu:latest":   "localhost:5000",
+		"registry.example.org/team/java": "registry.example.org",
+	} {
+		if host := registryHost(image); host != expected {
+			t.Errorf("registry of %s should be %s, was %s", image, expected, host)
+		}
+	}
+}
+
+func TestRegistryAuth(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("user:secret"))
+
+	t.Run("Reads auths of the registry", func(t *testing.T) {
+		defer writeDockerConfig(t, `{"auths": {
+			"https://registry.example.org/v1/": {"auth": "`+auth+`"},
+			"https://index.docker.io/v1/": {"auth": "`+base64.StdEncoding.EncodeToString([]byte("hub:pass"))+`"}
+		}}`, nil)()
+
+		result, err := RegistryAuth("registry.example.org/team/java:8")
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		expected := docker.AuthConfiguration{Username: "user", Password: "secret", ServerAddress: "registry.example.org"}
+		if result != expected {
+			t.Errorf("should be %v, was %v", expected, result)
+		}
+
+		result, err = RegistryAuth("zalando/pazuzu")
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		expected = docker.AuthConfiguration{Username: "hub", Password: "pass", ServerAddress: dockerHubServerURL}
+		if result != expected {
+			t.Errorf("should be %v, was %v", expected, result)
+		}
+	})
+
+	t.Run("Asks the credential helper of the registry first", func(t *testing.T) {
+		defer writeDockerConfig(t, `{
+			"auths": {"registry.example.org": {"auth": "`+auth+`"}},
+			"credsStore": "store",
+			"credHelpers": {"registry.example.org": "ecr"}
+		}`, map[string]string{
+			"ecr":   `{"ServerURL": "registry.example.org", "Username": "AWS", "Secret": "token"}`,
+			"store": `{"ServerURL": "localhost:5000", "Username": "local", "Secret": "store"}`,
+		})()
+
+		result, err := RegistryAuth("registry.example.org/java")
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		expected := docker.AuthConfiguration{Username: "AWS", Password: "token", ServerAddress: "registry.example.org"}
+		if result != expected {
+			t.Errorf("should be %v, was %v", expected, result)
+		}
+
+		result, err = RegistryAuth("localhost:5000/java")
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		expected = docker.AuthConfiguration{Username: "local", Password: "store", ServerAddress: "localhost:5000"}
+		if result != expected {
+			t.Errorf("should be %v, was %v", expected, result)
+		}
+	})
+
+	t.Run("Pushes anonymously without credentials", func(t *testing.T) {
+		defer writeDockerConfig(t, `{"credsStore": "store"}`, map[string]string{
+			"store": "credentials not found in native keychain",
+		})()
+
+		result, err := RegistryAuth("localhost:5000/java")
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if result != (docker.AuthConfiguration{}) {
+			t.Errorf("should be empty, was %v", result)
+		}
+	})
+
+	t.Run("Fails for identity tokens", func(t *testing.T) {
+		defer writeDockerConfig(t, `{"credHelpers": {"registry.example.org": "token"}}`, map[string]string{
+			"token": `{"ServerURL": "registry.example.org", "Username": "<token>", "Secret": "refresh"}`,
+		})()
+
+		_, err := RegistryAuth("registry.example.org/java")
+		if err == nil || !strings.Contains(err.Error(), "identity tokens are not supported") {
+			t.Errorf("should fail for identity tokens, was %v", err)
+		}
+	})
+
+	t.Run("Fails for failing credential helpers", func(t *testing.T) {
+		defer writeDockerConfig(t, `{"credsStore": "missing"}`, nil)()
+
+		_, err := RegistryAuth("registry.example.org/java")
+		if err == nil || !strings.Contains(err.Error(), "credential helper missing failed") {
+			t.Errorf("should fail for the missing helper, was %v", err)
+		}
+	})
+}
@@ -0,0 +1,364 @@
+// Package registryserver implements the HTTP API of pazuzu-registry (see vendor/swaggen/swagger.yaml)
+// on top of any storage, so the registry storage can be used without running pazuzu-registry.
+package registryserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+	"github.com/zalando-incubator/pazuzu/storageconnector"
+	"swaggen/models"
+)
+
+const (
+	// BasePath : path all the API is served below.
+	BasePath = "/api"
+
+	defaultLimit    = 50
+	maxLimit        = 1000
+	maxDependencies = 128
+
+	// all the features of a storage are approved, there is no review process
+	statusApproved = "approved"
+)
+
+// Server serves features of Reader. Features are created, updated and deleted through Writer,
+// the registry is read-only if there is no Writer.
+type Server struct {
+	Reader storageconnector.StorageReader
+	Writer storageconnector.StorageWriter
+
+	lock sync.RWMutex // storages are not safe for concurrent writes
+}
+
+// NewServer creates a registry server, writer may be nil.
+func NewServer(reader storageconnector.StorageReader, writer storageconnector.StorageWriter) *Server {
+	return &Server{Reader: reader, Writer: writer}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, BasePath)
+	if path == r.URL.Path {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such resource: %s", r.URL.Path))
+		return
+	}
+
+	switch {
+	case path == "/features":
+		switch r.Method {
+		case http.MethodGet:
+			s.listFeatures(w, r)
+		case http.MethodPost:
+			s.createFeature(w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+
+	case strings.HasPrefix(path, "/features/"):
+		name := strings.TrimPrefix(path, "/features/")
+		switch r.Method {
+		case http.MethodGet:
+			s.getFeature(w, name)
+		case http.MethodPut:
+			s.updateFeature(w, r, name)
+		case http.MethodDelete:
+			s.deleteFeature(w, name)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+		}
+
+	case path == "/dependencies":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		s.getDependencies(w, r)
+
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such resource: %s", r.URL.Path))
+	}
+}
+
+// listFeatures returns features sorted by name. q is a regular expression matching a part of
+// the name, so a plain part of the name works as well. Only approved features exist.
+func (s *Server) listFeatures(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	expr, err := regexp.Compile(query.Get("q"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid q: %s", err))
+		return
+	}
+	offset, err := intParam(query, "offset", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := intParam(query, "limit", defaultLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	metas := []shared.FeatureMeta{}
+	if status := query.Get("status"); status == "" || status == statusApproved {
+		found, err := s.Reader.SearchMeta(expr)
+		if err != nil {
+			writeStorageError(w, err, http.StatusInternalServerError)
+			return
+		}
+		for _, meta := range found {
+			if author := query.Get("author"); author == "" || author == meta.Author {
+				metas = append(metas, meta)
+			}
+		}
+	}
+	sort.Sort(metasByName(metas))
+
+	total := len(metas)
+	start, end := offset, offset+limit
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+
+	list := &models.FeatureList{
+		TotalCount: int64(total),
+		Features:   []*models.Feature{},
+		Links:      &models.FeatureListLinks{},
+	}
+	for _, meta := range metas[start:end] {
+		feature, err := s.Reader.GetFeature(meta.Name)
+		if err != nil {
+			writeStorageError(w, err, http.StatusInternalServerError)
+			return
+		}
+		list.Features = append(list.Features, newModelFeature(feature))
+	}
+	if start > 0 {
+		list.Links.Prev = pageLink(r.URL, start-limit, limit)
+	}
+	if end < total {
+		list.Links.Next = pageLink(r.URL, end, limit)
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) getFeature(w http.ResponseWriter, name string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	feature, err := s.Reader.GetFeature(name)
+	if err != nil {
+		writeStorageError(w, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, newModelFeature(feature))
+}
+
+// getDependencies accepts names as comma-separated list as well as repeated parameter.
+func (s *Server) getDependencies(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for _, value := range r.URL.Query()["names"] {
+		for _, name := range strings.Split(value, ",") {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 || len(names) > maxDependencies {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("between 1 and %d names required", maxDependencies))
+		return
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	order, features, err := s.Reader.Resolve(names...)
+	if err != nil {
+		writeStorageError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	list := &models.DependenciesList{RequestedFeatures: names, Depedencies: []*models.Feature{}}
+	for _, name := range order {
+		list.Depedencies = append(list.Depedencies, newModelFeature(features[name]))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) createFeature(w http.ResponseWriter, r *http.Request) {
+	feature, err := readFeature(r, "")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.write(w, http.StatusCreated, feature.Meta.Name, func() error {
+		return s.Writer.CreateFeature(feature)
+	})
+}
+
+func (s *Server) updateFeature(w http.ResponseWriter, r *http.Request, name string) {
+	feature, err := readFeature(r, name)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.write(w, http.StatusOK, name, func() error {
+		return s.Writer.UpdateFeature(feature)
+	})
+}
+
+func (s *Server) deleteFeature(w http.ResponseWriter, name string) {
+	s.write(w, http.StatusNoContent, "", func() error {
+		return s.Writer.DeleteFeature(name)
+	})
+}
+
+// write runs a write operation and responds with the given feature as written to the storage,
+// or with no content if there is no name.
+func (s *Server) write(w http.ResponseWriter, code int, name string, operation func() error) {
+	if s.Writer == nil {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("registry is read-only"))
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := operation(); err != nil {
+		writeStorageError(w, err, http.StatusBadRequest)
+		return
+	}
+	if name == "" {
+		w.WriteHeader(code)
+		return
+	}
+
+	feature, err := s.Reader.GetFeature(name)
+	if err != nil {
+		writeStorageError(w, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, code, newModelFeature(feature))
+}
+
+// readFeature reads the feature of a request body. The name of the feature must be valid and
+// the same as name, if given.
+func readFeature(r *http.Request, name string) (shared.Feature, error) {
+	var model models.Feature
+	if err := json.NewDecoder(r.Body).Decode(&model); err != nil {
+		return shared.Feature{}, fmt.Errorf("invalid feature: %s", err)
+	}
+	if model.Meta == nil {
+		model.Meta = &models.FeatureMeta{}
+	}
+
+	switch {
+	case name == "":
+		name = model.Meta.Name
+	case model.Meta.Name == "":
+		model.Meta.Name = name
+	case model.Meta.Name != name:
+		return shared.Feature{}, fmt.Errorf("feature name '%s' differs from '%s'", model.Meta.Name, name)
+	}
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return shared.Feature{}, fmt.Errorf("invalid feature name '%s'", name)
+	}
+
+	return shared.NewFeature(&model), nil
+}
+
+func newModelFeature(feature shared.Feature) *models.Feature {
+	model := shared.NewModelFeature(feature)
+	model.Meta.Status = statusApproved
+	if !feature.Meta.UpdatedAt.IsZero() {
+		model.Meta.UpdatedAt = feature.Meta.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+	return model
+}
+
+func intParam(query url.Values, name string, defaultValue int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s '%s'", name, value)
+	}
+	return n, nil
+}
+
+func pageLink(u *url.URL, offset int, limit int) *models.Link {
+	if offset < 0 {
+		offset = 0
+	}
+
+	query := u.Query()
+	query.Set("offset", strconv.Itoa(offset))
+	query.Set("limit", strconv.Itoa(limit))
+	link := url.URL{Path: u.Path, RawQuery: query.Encode()}
+	return &models.Link{Href: link.String()}
+}
+
+// writeStorageError responds with the status code matching a storage error, code is used
+// for errors not known to be caused by the client.
+func writeStorageError(w http.ResponseWriter, err error, code int) {
+	switch err.(type) {
+	case *storageconnector.FeatureNotFoundError, *storageconnector.MissingDependencyError:
+		code = http.StatusNotFound
+	case *storageconnector.FeatureExistsError:
+		code = http.StatusConflict
+	case *storageconnector.DependencyCycleError:
+		code = http.StatusBadRequest
+	case *storageconnector.UnauthorizedError, *storageconnector.StorageUnavailableError:
+		code = http.StatusServiceUnavailable
+	}
+	writeError(w, code, err)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, &models.Error{
+		Code:    strconv.Itoa(code),
+		Message: err.Error(),
+	})
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
+
+type metasByName []shared.FeatureMeta
+
+func (m metasByName) Len() int           { return len(m) }
+func (m metasByName) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m metasByName) Less(i, j int) bool { return m[i].Name < m[j].Name }
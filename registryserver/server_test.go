@@ -0,0 +1,169 @@
+package registryserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+	"github.com/zalando-incubator/pazuzu/storageconnector"
+	"swaggen/models"
+)
+
+// setupServer serves a feature directory and returns the registry storage connected to it.
+func setupServer(t *testing.T, features ...shared.Feature) (*Server, storageconnector.StorageReader, storageconnector.StorageWriter, func()) {
+	root, err := ioutil.TempDir("", "pazuzu_registry_server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := storageconnector.NewLocalStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, feature := range features {
+		if err := local.CreateFeature(feature); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := NewServer(local, local)
+	httpServer := httptest.NewServer(server)
+
+	u, _ := url.Parse(httpServer.URL)
+	port, _ := strconv.Atoi(u.Port())
+	registry, err := storageconnector.NewRegistryStorage(u.Hostname(), port, u.Scheme, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server, registry, registry, func() {
+		httpServer.Close()
+		os.RemoveAll(root)
+	}
+}
+
+func TestServerWithRegistryStorage(t *testing.T) {
+	java := shared.NewFeature_str("java", "Java 8", "pazuzu", nil, "RUN install java", "@test \"java\" {\n}")
+	lein := shared.NewFeature_str("lein", "Leiningen", "team", []string{"java"}, "RUN install lein", "")
+	_, reader, writer, teardown := setupServer(t, java, lein)
+	defer teardown()
+
+	t.Run("GetFeature", func(t *testing.T) {
+		feature, err := reader.GetFeature("java")
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if feature.Meta.Description != "Java 8" || feature.Snippet != java.Snippet || feature.TestSnippet != java.TestSnippet {
+			t.Errorf("Wrong feature: %v", feature)
+		}
+		if feature.Meta.UpdatedAt.IsZero() {
+			t.Error("Update time should be sent")
+		}
+		if _, err := reader.GetFeature("python"); !storageconnector.IsFeatureNotFound(err) {
+			t.Errorf("Wrong error: %v", err)
+		}
+	})
+
+	t.Run("SearchMeta", func(t *testing.T) {
+		metas, err := reader.SearchMeta(regexp.MustCompile("^le"))
+		if err != nil || len(metas) != 1 || metas[0].Name != "lein" {
+			t.Errorf("Wrong search result: %v, %v", metas, err)
+		}
+	})
+
+	t.Run("Resolve", func(t *testing.T) {
+		names, features, err := reader.Resolve("lein")
+		if err != nil || !reflect.DeepEqual(names, []string{"java", "lein"}) || len(features) != 2 {
+			t.Errorf("Wrong resolve result: %v, %v", names, err)
+		}
+		if _, _, err := reader.Resolve("lein", "python"); err == nil {
+			t.Error("Missing feature should be reported")
+		}
+	})
+
+	t.Run("Write features", func(t *testing.T) {
+		clojure := shared.NewFeature_str("clojure", "Clojure", "team", []string{"lein"}, "RUN lein version", "")
+		if err := writer.CreateFeature(clojure); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if _, ok := writer.CreateFeature(clojure).(*storageconnector.FeatureExistsError); !ok {
+			t.Error("Existing feature should be reported")
+		}
+
+		clojure.Meta.Description = "Clojure 1.8"
+		if err := writer.UpdateFeature(clojure); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if meta, _ := reader.GetMeta("clojure"); meta.Description != "Clojure 1.8" {
+			t.Errorf("Feature should be updated: %v", meta)
+		}
+
+		if err := writer.DeleteFeature("lein"); err == nil {
+			t.Error("Feature with dependents should not be deleted")
+		}
+		if err := writer.DeleteFeature("clojure"); err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if err := writer.DeleteFeature("clojure"); !storageconnector.IsFeatureNotFound(err) {
+			t.Errorf("Wrong error: %v", err)
+		}
+	})
+}
+
+func TestServerFeatureList(t *testing.T) {
+	var features []shared.Feature
+	for _, name := range []string{"c", "a", "d", "b"} {
+		features = append(features, shared.NewFeature_str(name, "", "pazuzu", nil, "RUN echo "+name, ""))
+	}
+	features[3].Meta.Author = "team"
+	server, _, _, teardown := setupServer(t, features...)
+	defer teardown()
+
+	get := func(query string) (int, models.FeatureList) {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/features?"+query, nil))
+
+		var list models.FeatureList
+		json.Unmarshal(w.Body.Bytes(), &list)
+		return w.Code, list
+	}
+	names := func(list models.FeatureList) []string {
+		result := []string{}
+		for _, feature := range list.Features {
+			result = append(result, feature.Meta.Name)
+		}
+		return result
+	}
+
+	code, list := get("offset=1&limit=2")
+	if code != http.StatusOK || list.TotalCount != 4 || !reflect.DeepEqual(names(list), []string{"b", "c"}) {
+		t.Errorf("Wrong page: %d, %v", code, names(list))
+	}
+	if list.Links.Prev == nil || list.Links.Next == nil {
+		t.Errorf("Both links should be set: %v", list.Links)
+	}
+
+	if _, list := get("author=team"); !reflect.DeepEqual(names(list), []string{"b"}) {
+		t.Errorf("Wrong author filter: %v", names(list))
+	}
+	if _, list := get("status=pending"); list.TotalCount != 0 {
+		t.Errorf("There should be no pending features: %v", names(list))
+	}
+	if code, _ := get("limit=-1"); code != http.StatusBadRequest {
+		t.Errorf("Invalid limit should be refused: %d", code)
+	}
+
+	server.Writer = nil
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/features/a", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Read-only server should refuse writes: %d", w.Code)
+	}
+}
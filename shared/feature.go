@@ -13,6 +13,19 @@ type FeatureMeta struct {
 	Author       string
 	UpdatedAt    time.Time
 	Dependencies []string
+	Params       []FeatureParam
+	BuildOnly    bool     // only needed to build the image, installed in a build stage
+	Artifacts    []string // absolute paths copied from the build stage if BuildOnly
+	Platforms    []string // supported base image platforms as family[:version], any if empty
+	Source       string   // name of the storage the feature was found in, if read from several storages
+}
+
+// FeatureParam is a parameter of a Feature, given to the snippet as build argument.
+// The value is set in the Pazuzufile, Default is used otherwise.
+type FeatureParam struct {
+	Name        string
+	Default     string
+	Description string
 }
 
 // Feature is a definition for a piece of work to be done. Contains meta information as well as
@@ -21,6 +34,13 @@ type Feature struct {
 	Meta        FeatureMeta
 	Snippet     string
 	TestSnippet string
+	Files       map[string]FeatureFile // asset files by slash-separated path relative to the feature folder
+}
+
+// FeatureFile is an asset file of a Feature, copied into the image by the snippet.
+type FeatureFile struct {
+	Content    []byte
+	Executable bool
 }
 
 func NewFeature(feature *models.Feature) Feature {
@@ -28,6 +48,12 @@ func NewFeature(feature *models.Feature) Feature {
 	f.Meta = NewMeta(feature.Meta)
 	f.Snippet = feature.Snippet
 	f.TestSnippet = feature.TestSnippet
+	for path, file := range feature.Files {
+		if f.Files == nil {
+			f.Files = map[string]FeatureFile{}
+		}
+		f.Files[path] = FeatureFile{Content: file.Content, Executable: file.Executable}
+	}
 	return f
 }
 
@@ -36,17 +62,54 @@ func NewFeature_str(name string, desc string, auth string, dependencies []string
 	return Feature{Meta: m, Snippet: snippet, TestSnippet: testSnippet}
 }
 
+// NewModelFeature converts Feature to the registry representation.
+func NewModelFeature(feature Feature) *models.Feature {
+	var files map[string]models.FeatureFile
+	for path, file := range feature.Files {
+		if files == nil {
+			files = map[string]models.FeatureFile{}
+		}
+		files[path] = models.FeatureFile{Content: file.Content, Executable: file.Executable}
+	}
+
+	return &models.Feature{
+		Meta:        NewModelMeta(feature.Meta),
+		Snippet:     feature.Snippet,
+		TestSnippet: feature.TestSnippet,
+		Files:       files,
+	}
+}
+
 func NewMeta(meta *models.FeatureMeta) FeatureMeta {
 	var m FeatureMeta
 	m.Name = meta.Name
 	m.Description = meta.Description
 	m.Author = meta.Author
-	m.UpdatedAt, _ = time.Parse(meta.UpdatedAt, "2006-01-02T15:04:05-0700")
+	m.UpdatedAt = parseUpdatedAt(meta.UpdatedAt)
 	m.Dependencies = meta.Dependencies
+	m.BuildOnly = meta.BuildOnly
+	m.Artifacts = meta.Artifacts
+	m.Platforms = meta.Platforms
+	for _, param := range meta.Params {
+		if param != nil {
+			m.Params = append(m.Params, FeatureParam{Name: param.Name, Default: param.Default, Description: param.Description})
+		}
+	}
 
 	return m
 }
 
+// parseUpdatedAt accepts RFC 3339 as well as numeric zone offsets without a colon.
+// Zero time is returned if the registry gives no or an unknown time.
+func parseUpdatedAt(value string) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05-0700"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
 func NewMeta_str(name string, desc string, auth string, dependencies []string) FeatureMeta {
 	var m FeatureMeta
 	m.Name = name
@@ -56,3 +119,35 @@ func NewMeta_str(name string, desc string, auth string, dependencies []string) F
 
 	return m
 }
+
+// NewModelMeta converts FeatureMeta to the registry representation. Update time is left
+// to the registry.
+func NewModelMeta(meta FeatureMeta) *models.FeatureMeta {
+	dependencies := meta.Dependencies
+	if dependencies == nil {
+		dependencies = []string{}
+	}
+	artifacts := meta.Artifacts
+	if artifacts == nil {
+		artifacts = []string{}
+	}
+	platforms := meta.Platforms
+	if platforms == nil {
+		platforms = []string{}
+	}
+	params := []*models.FeatureParam{}
+	for _, param := range meta.Params {
+		params = append(params, &models.FeatureParam{Name: param.Name, Default: param.Default, Description: param.Description})
+	}
+
+	return &models.FeatureMeta{
+		Name:         meta.Name,
+		Description:  meta.Description,
+		Author:       meta.Author,
+		Dependencies: dependencies,
+		Params:       params,
+		BuildOnly:    meta.BuildOnly,
+		Artifacts:    artifacts,
+		Platforms:    platforms,
+	}
+}
@@ -0,0 +1,61 @@
+package pazuzu
+
+import (
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+// buildStageName is the name of the stage build-only features are installed in.
+const buildStageName = "pazuzu-build"
+
+// splitStages splits resolved features into the features of the build stage and of the final
+// stage, both in dependency order. The build stage gets the build-only features together with
+// everything they depend on. The final stage gets the requested features and their dependencies,
+// except for what is needed by build-only features only. Build-only features of the final stage
+// stand for their artifacts, copied from the build stage.
+func splitStages(requested []string, features []shared.Feature) (build []shared.Feature, final []shared.Feature) {
+	inBuild := map[string]bool{}
+	inFinal := map[string]bool{}
+	for _, name := range requested {
+		inFinal[name] = true
+	}
+
+	// dependencies are always placed before the features depending on them
+	for i := len(features) - 1; i >= 0; i-- {
+		meta := features[i].Meta
+		if meta.BuildOnly || inBuild[meta.Name] {
+			inBuild[meta.Name] = true
+			for _, dependency := range meta.Dependencies {
+				inBuild[dependency] = true
+			}
+		}
+		if inFinal[meta.Name] && !meta.BuildOnly {
+			for _, dependency := range meta.Dependencies {
+				inFinal[dependency] = true
+			}
+		}
+	}
+
+	if len(inBuild) == 0 {
+		return nil, features
+	}
+	for _, feature := range features {
+		if inBuild[feature.Meta.Name] {
+			build = append(build, feature)
+		}
+		if inFinal[feature.Meta.Name] {
+			final = append(final, feature)
+		}
+	}
+	return build, final
+}
+
+// runtimeFeatures returns the features installed in the final image.
+func runtimeFeatures(features []shared.Feature) []shared.Feature {
+	var result []shared.Feature
+	for _, feature := range features {
+		if !feature.Meta.BuildOnly {
+			result = append(result, feature)
+		}
+	}
+	return result
+}
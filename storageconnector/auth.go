@@ -0,0 +1,61 @@
+package storageconnector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// TokenSource returns the OAuth2 bearer token to be sent to the registry, or an empty token for none.
+// It is called for every request, so tokens refreshed in the meantime are picked up.
+type TokenSource func() (string, error)
+
+// StaticToken always returns the given token.
+func StaticToken(token string) TokenSource {
+	return func() (string, error) {
+		return token, nil
+	}
+}
+
+// FileToken reads the token from a file, which is expected to be kept up to date by a credential helper.
+func FileToken(path string) TokenSource {
+	return func() (string, error) {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("cannot read token file: %s", err)
+		}
+		return strings.TrimSpace(string(content)), nil
+	}
+}
+
+// bearerTransport adds the token of the registry to every request.
+type bearerTransport struct {
+	base  http.RoundTripper
+	store *registryStorage
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.store.Token == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	token, err := t.store.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	// requests must not be modified by a RoundTripper
+	authReq := new(http.Request)
+	*authReq = *req
+	authReq.Header = make(http.Header, len(req.Header)+1)
+	for key, values := range req.Header {
+		authReq.Header[key] = values
+	}
+	authReq.Header.Set("Authorization", "Bearer "+token)
+
+	return t.base.RoundTrip(authReq)
+}
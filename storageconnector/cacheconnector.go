@@ -0,0 +1,328 @@
+package storageconnector
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+// Cached data is kept in the following folders of the cache root, one file per feature.
+// Features are kept together with the update time given by the source, so an unchanged
+// feature is not fetched again once its meta is known to be up to date.
+const (
+	cacheMetaDir    = "meta"
+	cacheFeatureDir = "features"
+	cacheExt        = ".yml"
+)
+
+// NotCachedError is returned by the cache when a feature is required but there is
+// no cached copy of it and the source may not be asked (offline mode).
+type NotCachedError struct {
+	Name string
+}
+
+func (e *NotCachedError) Error() string {
+	return fmt.Sprintf("feature '%s' is not cached, it can not be fetched in offline mode", e.Name)
+}
+
+// cacheEntry is the content of a cache file. Snippets are empty for meta entries.
+type cacheEntry struct {
+	FetchedAt    string               `yaml:"fetched_at"`
+	Description  string               `yaml:"description"`
+	Author       string               `yaml:"author"`
+	UpdatedAt    string               `yaml:"updated_at,omitempty"`
+	Dependencies []string             `yaml:"dependencies"`
+	Params       []folderParam        `yaml:"params,omitempty"`
+	BuildOnly    bool                 `yaml:"build_only,omitempty"`
+	Artifacts    []string             `yaml:"artifacts,omitempty"`
+	Platforms    []string             `yaml:"platforms,omitempty"`
+	Snippet      string               `yaml:"snippet,omitempty"`
+	TestSnippet  string               `yaml:"test_snippet,omitempty"`
+	Files        map[string]cacheFile `yaml:"files,omitempty"`
+}
+
+// cacheFile is an asset file of a cache entry, the content is base64 encoded.
+type cacheFile struct {
+	Content    string `yaml:"content"`
+	Executable bool   `yaml:"executable,omitempty"`
+}
+
+// cacheStorage is a StorageReader keeping everything read from another StorageReader on disk.
+// Cached data is used without asking the source until it is older than TTL. If the source
+// is unavailable, cached data is used regardless of its age. In offline mode the source
+// is never asked.
+type cacheStorage struct {
+	Root    string        // ~/.cache/pazuzu/<storage>
+	TTL     time.Duration // 0 asks the source every time
+	Offline bool
+	Source  StorageReader
+}
+
+// NewCacheStorage wraps source with a cache kept in root directory.
+func NewCacheStorage(source StorageReader, root string, ttl time.Duration, offline bool) (*cacheStorage, error) {
+	for _, dir := range []string{cacheMetaDir, cacheFeatureDir} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			return nil, &StorageUnavailableError{Storage: root, Err: err}
+		}
+	}
+
+	return &cacheStorage{Root: root, TTL: ttl, Offline: offline, Source: source}, nil
+}
+
+func (c *cacheStorage) SearchMeta(name *regexp.Regexp) ([]shared.FeatureMeta, error) {
+	if !c.Offline {
+		metas, err := c.Source.SearchMeta(name)
+		if !IsStorageUnavailable(err) {
+			for _, meta := range metas {
+				c.store(cacheMetaDir, shared.Feature{Meta: meta})
+			}
+			return metas, err
+		}
+	}
+
+	infos, err := ioutil.ReadDir(filepath.Join(c.Root, cacheMetaDir))
+	if err != nil {
+		return []shared.FeatureMeta{}, &StorageUnavailableError{Storage: c.Root, Err: err}
+	}
+
+	view := c.view(true)
+	result := []shared.FeatureMeta{}
+	for _, info := range infos {
+		featureName := strings.TrimSuffix(info.Name(), cacheExt)
+		if !strings.HasSuffix(info.Name(), cacheExt) || !name.MatchString(featureName) {
+			continue
+		}
+		if meta, err := view.GetMeta(featureName); err == nil {
+			result = append(result, meta)
+		}
+	}
+
+	return result, nil
+}
+
+func (c *cacheStorage) GetMeta(name string) (shared.FeatureMeta, error) {
+	if meta, err := c.view(c.Offline).GetMeta(name); err == nil || c.Offline {
+		return meta, err
+	}
+
+	meta, err := c.Source.GetMeta(name)
+	switch {
+	case err == nil:
+		c.store(cacheMetaDir, shared.Feature{Meta: meta})
+	case IsStorageUnavailable(err):
+		if cached, cacheErr := c.view(true).GetMeta(name); cacheErr == nil {
+			return cached, nil
+		}
+	case IsFeatureNotFound(err):
+		c.remove(name)
+	}
+	return meta, err
+}
+
+func (c *cacheStorage) GetFeature(name string) (shared.Feature, error) {
+	if feature, err := c.view(c.Offline).GetFeature(name); err == nil || c.Offline {
+		return feature, err
+	}
+
+	feature, err := c.Source.GetFeature(name)
+	switch {
+	case err == nil:
+		c.storeFeature(feature)
+	case IsStorageUnavailable(err):
+		if cached, cacheErr := c.view(true).GetFeature(name); cacheErr == nil {
+			return cached, nil
+		}
+	case IsFeatureNotFound(err):
+		c.remove(name)
+	}
+	return feature, err
+}
+
+// Resolve features from the cache if all of them are up to date, otherwise from the source.
+func (c *cacheStorage) Resolve(names ...string) ([]string, map[string]shared.Feature, error) {
+	if !c.Offline {
+		if order, features, err := ResolveDependencies(c.view(false), names...); err == nil {
+			return order, features, nil
+		}
+
+		order, features, err := c.Source.Resolve(names...)
+		if !IsStorageUnavailable(err) {
+			for _, feature := range features {
+				c.storeFeature(feature)
+			}
+			return order, features, err
+		}
+
+		// report the unavailable source rather than incomplete cache
+		if order, features, cacheErr := ResolveDependencies(c.view(true), names...); cacheErr == nil {
+			return order, features, nil
+		}
+		return order, features, err
+	}
+
+	return ResolveDependencies(c.view(true), names...)
+}
+
+// view returns the cached data, including data older than TTL if stale is set.
+func (c *cacheStorage) view(stale bool) *cacheView {
+	return &cacheView{cache: c, stale: stale}
+}
+
+func (c *cacheStorage) path(dir string, name string) string {
+	return filepath.Join(c.Root, dir, name+cacheExt)
+}
+
+// lookup reads a cache entry, ok is false if there is no usable entry.
+func (c *cacheStorage) lookup(dir string, name string) (feature shared.Feature, fetchedAt time.Time, ok bool) {
+	if !ValidFeatureName(name) {
+		return shared.Feature{}, time.Time{}, false
+	}
+
+	content, err := ioutil.ReadFile(c.path(dir, name))
+	if err != nil {
+		return shared.Feature{}, time.Time{}, false
+	}
+
+	var entry cacheEntry
+	if err := yaml.Unmarshal(content, &entry); err != nil {
+		return shared.Feature{}, time.Time{}, false
+	}
+	fetchedAt, err = time.Parse(time.RFC3339Nano, entry.FetchedAt)
+	if err != nil {
+		return shared.Feature{}, time.Time{}, false
+	}
+
+	meta := shared.NewMeta_str(name, entry.Description, entry.Author, entry.Dependencies)
+	meta.Params = newFeatureParams(entry.Params)
+	meta.BuildOnly = entry.BuildOnly
+	meta.Artifacts = entry.Artifacts
+	meta.Platforms = entry.Platforms
+	if entry.UpdatedAt != "" {
+		meta.UpdatedAt, _ = time.Parse(time.RFC3339Nano, entry.UpdatedAt)
+	}
+
+	var files map[string]shared.FeatureFile
+	for path, file := range entry.Files {
+		content, err := base64.StdEncoding.DecodeString(file.Content)
+		if err != nil {
+			return shared.Feature{}, time.Time{}, false
+		}
+		if files == nil {
+			files = map[string]shared.FeatureFile{}
+		}
+		files[path] = shared.FeatureFile{Content: content, Executable: file.Executable}
+	}
+
+	return shared.Feature{Meta: meta, Snippet: entry.Snippet, TestSnippet: entry.TestSnippet, Files: files}, fetchedAt, true
+}
+
+func (c *cacheStorage) storeFeature(feature shared.Feature) {
+	c.store(cacheFeatureDir, feature)
+	c.store(cacheMetaDir, shared.Feature{Meta: feature.Meta})
+}
+
+// store writes a cache entry. The cache is best-effort, so a failing write is not an error,
+// the entry is fetched again next time.
+func (c *cacheStorage) store(dir string, feature shared.Feature) {
+	name := feature.Meta.Name
+	if !ValidFeatureName(name) {
+		return
+	}
+
+	entry := cacheEntry{
+		FetchedAt:    time.Now().UTC().Format(time.RFC3339Nano),
+		Description:  feature.Meta.Description,
+		Author:       feature.Meta.Author,
+		Dependencies: feature.Meta.Dependencies,
+		Params:       newFolderParams(feature.Meta.Params),
+		BuildOnly:    feature.Meta.BuildOnly,
+		Artifacts:    feature.Meta.Artifacts,
+		Platforms:    feature.Meta.Platforms,
+		Snippet:      feature.Snippet,
+		TestSnippet:  feature.TestSnippet,
+	}
+	if !feature.Meta.UpdatedAt.IsZero() {
+		entry.UpdatedAt = feature.Meta.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	for path, file := range feature.Files {
+		if entry.Files == nil {
+			entry.Files = map[string]cacheFile{}
+		}
+		entry.Files[path] = cacheFile{Content: base64.StdEncoding.EncodeToString(file.Content), Executable: file.Executable}
+	}
+
+	content, err := yaml.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	// concurrent runs of pazuzu must never see half-written entries
+	temp, err := ioutil.TempFile(filepath.Join(c.Root, dir), "."+name+".")
+	if err != nil {
+		return
+	}
+	_, err = temp.Write(content)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), c.path(dir, name))
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+}
+
+// remove drops all cached data of a feature which does not exist anymore.
+func (c *cacheStorage) remove(name string) {
+	if !ValidFeatureName(name) {
+		return
+	}
+	os.Remove(c.path(cacheMetaDir, name))
+	os.Remove(c.path(cacheFeatureDir, name))
+}
+
+// cacheView is a FeatureGetter over the cached data only.
+type cacheView struct {
+	cache *cacheStorage
+	stale bool
+}
+
+func (v *cacheView) fresh(fetchedAt time.Time) bool {
+	return v.stale || time.Since(fetchedAt) < v.cache.TTL
+}
+
+func (v *cacheView) GetMeta(name string) (shared.FeatureMeta, error) {
+	feature, fetchedAt, ok := v.cache.lookup(cacheMetaDir, name)
+	if !ok || !v.fresh(fetchedAt) {
+		return shared.FeatureMeta{}, &NotCachedError{Name: name}
+	}
+	return feature.Meta, nil
+}
+
+// GetFeature returns a cached feature if it is up to date itself, or if its meta is
+// up to date and was not updated since the feature was fetched.
+func (v *cacheView) GetFeature(name string) (shared.Feature, error) {
+	feature, fetchedAt, ok := v.cache.lookup(cacheFeatureDir, name)
+	if !ok {
+		return shared.Feature{}, &NotCachedError{Name: name}
+	}
+	if v.fresh(fetchedAt) {
+		return feature, nil
+	}
+
+	meta, err := v.GetMeta(name)
+	if err != nil || meta.UpdatedAt.IsZero() || !meta.UpdatedAt.Equal(feature.Meta.UpdatedAt) {
+		return shared.Feature{}, &NotCachedError{Name: name}
+	}
+	return feature, nil
+}
@@ -0,0 +1,168 @@
+package storageconnector
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+// testSource is a storage counting its requests, which can be made unavailable.
+type testSource struct {
+	featureMap
+	unavailable bool
+	requests    int
+}
+
+func (s *testSource) request() error {
+	s.requests++
+	if s.unavailable {
+		return &StorageUnavailableError{Storage: "test", Err: os.ErrNotExist}
+	}
+	return nil
+}
+
+func (s *testSource) SearchMeta(name *regexp.Regexp) ([]shared.FeatureMeta, error) {
+	if err := s.request(); err != nil {
+		return []shared.FeatureMeta{}, err
+	}
+	result := []shared.FeatureMeta{}
+	for _, feature := range s.featureMap {
+		if name.MatchString(feature.Meta.Name) {
+			result = append(result, feature.Meta)
+		}
+	}
+	return result, nil
+}
+
+func (s *testSource) GetMeta(name string) (shared.FeatureMeta, error) {
+	if err := s.request(); err != nil {
+		return shared.FeatureMeta{}, err
+	}
+	return s.featureMap.GetMeta(name)
+}
+
+func (s *testSource) GetFeature(name string) (shared.Feature, error) {
+	if err := s.request(); err != nil {
+		return shared.Feature{}, err
+	}
+	return s.featureMap.GetFeature(name)
+}
+
+func (s *testSource) Resolve(names ...string) ([]string, map[string]shared.Feature, error) {
+	if err := s.request(); err != nil {
+		return []string{}, map[string]shared.Feature{}, err
+	}
+	return ResolveDependencies(s.featureMap, names...)
+}
+
+func setupCacheStorage(t *testing.T, ttl time.Duration) (*cacheStorage, *testSource, string) {
+	java := shared.NewFeature_str("java", "Java 8", "pazuzu", nil, "RUN install java", "")
+	java.Meta.UpdatedAt = time.Date(2016, 12, 1, 10, 0, 0, 0, time.UTC)
+	lein := shared.NewFeature_str("lein", "Leiningen", "pazuzu", []string{"java"}, "RUN install lein", "")
+	source := &testSource{featureMap: featureMap{"java": java, "lein": lein}}
+
+	root, err := ioutil.TempDir("", "pazuzu_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := NewCacheStorage(source, root, ttl, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache, source, root
+}
+
+func TestCacheStorage(t *testing.T) {
+	t.Run("Features are not fetched again within TTL", func(t *testing.T) {
+		cache, source, root := setupCacheStorage(t, time.Hour)
+		defer os.RemoveAll(root)
+
+		for i := 0; i < 2; i++ {
+			names, features, err := cache.Resolve("lein")
+			if err != nil || !reflect.DeepEqual(names, []string{"java", "lein"}) || len(features) != 2 {
+				t.Fatalf("Wrong resolve result: %v, %v", names, err)
+			}
+		}
+		if source.requests != 1 {
+			t.Errorf("Source should be asked once, not %d times", source.requests)
+		}
+		if feature, _ := cache.GetFeature("java"); !feature.Meta.UpdatedAt.Equal(source.featureMap["java"].Meta.UpdatedAt) {
+			t.Errorf("Update time should be cached: %v", feature.Meta)
+		}
+	})
+
+	t.Run("Unchanged features are not fetched again after TTL", func(t *testing.T) {
+		cache, source, root := setupCacheStorage(t, 0)
+		defer os.RemoveAll(root)
+
+		cache.GetFeature("java")
+		cache.GetMeta("java")
+		cache.TTL = time.Hour
+		source.requests = 0
+
+		if feature, err := cache.GetFeature("java"); err != nil || feature.Snippet != "RUN install java" {
+			t.Errorf("Feature should be cached: %v, %v", feature, err)
+		}
+		if source.requests != 0 {
+			t.Errorf("Source should not be asked, but was asked %d times", source.requests)
+		}
+	})
+
+	t.Run("Cached features are used if the source is unavailable", func(t *testing.T) {
+		cache, source, root := setupCacheStorage(t, 0)
+		defer os.RemoveAll(root)
+
+		cache.Resolve("lein")
+		source.unavailable = true
+
+		if names, _, err := cache.Resolve("lein"); err != nil || len(names) != 2 {
+			t.Errorf("Features should be resolved from the cache: %v, %v", names, err)
+		}
+		if metas, err := cache.SearchMeta(regexp.MustCompile("^j")); err != nil || len(metas) != 1 {
+			t.Errorf("Wrong search result: %v, %v", metas, err)
+		}
+		if _, err := cache.GetFeature("python"); !IsStorageUnavailable(err) {
+			t.Errorf("Unavailable source should be reported for missing features: %v", err)
+		}
+	})
+
+	t.Run("Offline mode never asks the source", func(t *testing.T) {
+		cache, source, root := setupCacheStorage(t, 0)
+		defer os.RemoveAll(root)
+
+		cache.GetFeature("java")
+		cache.Offline = true
+		source.requests = 0
+
+		if _, err := cache.GetFeature("java"); err != nil {
+			t.Errorf("Feature should be cached: %v", err)
+		}
+		_, _, err := cache.Resolve("lein")
+		if err, ok := err.(*MissingDependencyError); !ok || !reflect.DeepEqual(err.Err, &NotCachedError{Name: "lein"}) {
+			t.Errorf("Wrong error: %v", err)
+		}
+		if source.requests != 0 {
+			t.Errorf("Source should not be asked, but was asked %d times", source.requests)
+		}
+	})
+
+	t.Run("Removed features are dropped from the cache", func(t *testing.T) {
+		cache, source, root := setupCacheStorage(t, 0)
+		defer os.RemoveAll(root)
+
+		cache.GetFeature("java")
+		delete(source.featureMap, "java")
+
+		if _, err := cache.GetFeature("java"); !IsFeatureNotFound(err) {
+			t.Errorf("Wrong error: %v", err)
+		}
+		if _, err := cache.view(true).GetFeature("java"); err == nil {
+			t.Error("Feature should be removed from the cache")
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package storageconnector
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-openapi/runtime"
+)
+
+// FeatureNotFoundError is returned by storages when there is no feature with the given name.
+type FeatureNotFoundError struct {
+	Name string
+}
+
+func (e *FeatureNotFoundError) Error() string {
+	return fmt.Sprintf("feature '%s' not found", e.Name)
+}
+
+// FeatureExistsError is returned by storages when a feature to be created already exists.
+type FeatureExistsError struct {
+	Name string
+}
+
+func (e *FeatureExistsError) Error() string {
+	return fmt.Sprintf("feature '%s' already exists", e.Name)
+}
+
+// StorageUnavailableError is returned by storages when the storage itself can not be reached or read.
+type StorageUnavailableError struct {
+	Storage string // human-readable location of the storage
+	Err     error
+}
+
+func (e *StorageUnavailableError) Error() string {
+	return fmt.Sprintf("storage %s is unavailable: %s", e.Storage, e.Err)
+}
+
+// UnauthorizedError is returned by the registry when a request has no valid token (401)
+// or the token does not grant access (403).
+type UnauthorizedError struct {
+	Storage string
+	Code    int
+}
+
+func (e *UnauthorizedError) Error() string {
+	if e.Code == http.StatusForbidden {
+		return fmt.Sprintf("storage %s denied access (%d): the token does not grant access to this operation", e.Storage, e.Code)
+	}
+	return fmt.Sprintf("storage %s requires authentication (%d): provide an OAuth2 token with "+
+		"PAZUZU_TOKEN environment variable, registry.token_file or registry.token configuration", e.Storage, e.Code)
+}
+
+// IsFeatureNotFound returns true if err reports a missing feature.
+func IsFeatureNotFound(err error) bool {
+	_, ok := err.(*FeatureNotFoundError)
+	return ok
+}
+
+// IsNotCached returns true if err reports a feature missing in the cache in offline mode.
+func IsNotCached(err error) bool {
+	_, ok := err.(*NotCachedError)
+	return ok
+}
+
+// IsStorageUnavailable returns true if err reports an unavailable storage.
+func IsStorageUnavailable(err error) bool {
+	_, ok := err.(*StorageUnavailableError)
+	return ok
+}
+
+// IsStorageError returns true if err is about the storage itself rather than about a feature,
+// so there is no point to retry with other features.
+func IsStorageError(err error) bool {
+	_, ok := err.(*UnauthorizedError)
+	return ok || IsStorageUnavailable(err)
+}
+
+// registryStatusCode extracts the HTTP status code from errors returned by the registry client.
+func registryStatusCode(err error) (int, bool) {
+	switch err := err.(type) {
+	case interface {
+		Code() int
+	}:
+		return err.Code(), true
+	case *runtime.APIError:
+		return err.Code, true
+	}
+	return 0, false
+}
+
+// registryError converts errors of the registry client into storage errors.
+// name is the feature the request was made for, if any.
+func registryError(storage string, name string, err error) error {
+	code, ok := registryStatusCode(err)
+	switch {
+	case !ok || code >= http.StatusInternalServerError:
+		return &StorageUnavailableError{Storage: storage, Err: err}
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return &UnauthorizedError{Storage: storage, Code: code}
+	case code == http.StatusNotFound && name != "":
+		return &FeatureNotFoundError{Name: name}
+	case code == http.StatusConflict && name != "":
+		return &FeatureExistsError{Name: name}
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+package storageconnector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+// Every feature kept outside of the registry lives in its own folder named after
+// the feature and contains the following files. Any other file is an asset of the feature.
+const (
+	MetaFilename        = "meta.yml"
+	SnippetFilename     = "Dockerfile"
+	TestSnippetFilename = shared.TestSpecFilename
+)
+
+// isAssetPath returns false for the files of a feature folder which are not assets.
+// path is slash-separated and relative to the feature folder.
+func isAssetPath(path string) bool {
+	switch path {
+	case MetaFilename, SnippetFilename, TestSnippetFilename:
+		return false
+	}
+	return true
+}
+
+// ValidAssetPath checks that an asset can be written without escaping the feature folder,
+// path is slash-separated.
+func ValidAssetPath(path string) bool {
+	if path == "" || strings.HasPrefix(path, "/") || strings.Contains(path, `\`) || !isAssetPath(path) {
+		return false
+	}
+	for _, part := range strings.Split(path, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// folderMeta is the content of the meta file of a feature folder.
+type folderMeta struct {
+	Description  string        `yaml:"description"`
+	Author       string        `yaml:"author"`
+	UpdatedAt    string        `yaml:"updated_at,omitempty"`
+	Dependencies []string      `yaml:"dependencies"`
+	Params       []folderParam `yaml:"params,omitempty"`
+	BuildOnly    bool          `yaml:"build_only,omitempty"`
+	Artifacts    []string      `yaml:"artifacts,omitempty"`
+	Platforms    []string      `yaml:"platforms,omitempty"`
+}
+
+// folderParam is a parameter declared in a meta file.
+type folderParam struct {
+	Name        string `yaml:"name"`
+	Default     string `yaml:"default"`
+	Description string `yaml:"description,omitempty"`
+}
+
+func newFolderParams(params []shared.FeatureParam) []folderParam {
+	var result []folderParam
+	for _, param := range params {
+		result = append(result, folderParam{Name: param.Name, Default: param.Default, Description: param.Description})
+	}
+	return result
+}
+
+func newFeatureParams(params []folderParam) []shared.FeatureParam {
+	var result []shared.FeatureParam
+	for _, param := range params {
+		result = append(result, shared.FeatureParam{Name: param.Name, Default: param.Default, Description: param.Description})
+	}
+	return result
+}
+
+// ValidFeatureName checks that a name can be used as a folder name without
+// escaping the storage root.
+func ValidFeatureName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// parseFolderMeta creates FeatureMeta from the content of a meta file. modTime is
+// used when the meta file does not declare updated_at by itself.
+func parseFolderMeta(name string, content []byte, modTime time.Time) (shared.FeatureMeta, error) {
+	var fm folderMeta
+	if err := yaml.Unmarshal(content, &fm); err != nil {
+		return shared.FeatureMeta{}, fmt.Errorf("invalid %s of feature '%s': %s", MetaFilename, name, err)
+	}
+
+	meta := shared.NewMeta_str(name, fm.Description, fm.Author, fm.Dependencies)
+	meta.Params = newFeatureParams(fm.Params)
+	meta.BuildOnly = fm.BuildOnly
+	meta.Artifacts = fm.Artifacts
+	meta.Platforms = fm.Platforms
+	meta.UpdatedAt = modTime
+	if fm.UpdatedAt != "" {
+		updatedAt, err := time.Parse(time.RFC3339, fm.UpdatedAt)
+		if err != nil {
+			return shared.FeatureMeta{}, fmt.Errorf("invalid updated_at of feature '%s': %s", name, err)
+		}
+		meta.UpdatedAt = updatedAt
+	}
+
+	return meta, nil
+}
+
+// marshalFolderMeta creates the content of a meta file. Update time is left to the storage.
+func marshalFolderMeta(meta shared.FeatureMeta) ([]byte, error) {
+	return yaml.Marshal(folderMeta{
+		Description:  meta.Description,
+		Author:       meta.Author,
+		Dependencies: meta.Dependencies,
+		Params:       newFolderParams(meta.Params),
+		BuildOnly:    meta.BuildOnly,
+		Artifacts:    meta.Artifacts,
+		Platforms:    meta.Platforms,
+	})
+}
+
+// readFeatureFolder reads a full feature described by meta. readFile returns the content of
+// a file of the feature folder or an error satisfying os.IsNotExist when there is no such file,
+// readAssets returns all the asset files of the feature folder.
+func readFeatureFolder(meta shared.FeatureMeta, readFile func(filename string) ([]byte, error),
+	readAssets func() (map[string]shared.FeatureFile, error)) (shared.Feature, error) {
+	snippet, err := readFile(SnippetFilename)
+	if err != nil {
+		return shared.Feature{}, err
+	}
+
+	// test snippet is optional
+	testSnippet, err := readFile(TestSnippetFilename)
+	if err != nil && !os.IsNotExist(err) {
+		return shared.Feature{}, err
+	}
+
+	files, err := readAssets()
+	if err != nil {
+		return shared.Feature{}, err
+	}
+
+	return shared.Feature{
+		Meta:        meta,
+		Snippet:     string(snippet),
+		TestSnippet: string(testSnippet),
+		Files:       files,
+	}, nil
+}
+
+// ReadFeatureFolder reads a feature from a folder, outside of any storage. The feature is
+// named after the folder.
+func ReadFeatureFolder(dir string) (shared.Feature, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return shared.Feature{}, err
+	}
+
+	store := &localStorage{Root: filepath.Dir(dir)}
+	return store.GetFeature(filepath.Base(dir))
+}
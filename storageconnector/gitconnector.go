@@ -0,0 +1,235 @@
+package storageconnector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/core"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+// maxTagDepth limits how many annotated tags pointing to other tags are followed.
+const maxTagDepth = 16
+
+type gitStorage struct {
+	Path string // working copy or bare repository
+	Ref  string // branch, tag or full commit hash, HEAD if empty
+
+	Commit     *git.Commit
+	repository *git.Repository
+	tree       *git.Tree
+}
+
+// NewGitStorage opens the repository at path and pins the storage to the tree of ref.
+// Features are read from the committed tree, local changes of a working copy are ignored.
+func NewGitStorage(path string, ref string) (*gitStorage, error) {
+	repository, err := openGitRepository(path)
+	if err != nil {
+		return nil, &StorageUnavailableError{Storage: path, Err: err}
+	}
+
+	hash, err := resolveGitRef(repository, ref)
+	if err != nil {
+		return nil, &StorageUnavailableError{Storage: path, Err: fmt.Errorf("cannot resolve '%s': %s", ref, err)}
+	}
+
+	commit, err := peelToCommit(repository, hash)
+	if err != nil {
+		return nil, &StorageUnavailableError{Storage: path, Err: fmt.Errorf("cannot read '%s': %s", ref, err)}
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, &StorageUnavailableError{Storage: path, Err: err}
+	}
+
+	return &gitStorage{Path: path, Ref: ref, Commit: commit, repository: repository, tree: tree}, nil
+}
+
+func openGitRepository(path string) (*git.Repository, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("'%s' is not a directory", path)
+	}
+
+	dotGit := filepath.Join(path, ".git")
+	if info, err := os.Stat(dotGit); err == nil && info.IsDir() {
+		path = dotGit
+	}
+
+	return git.NewFilesystemRepository(path)
+}
+
+// resolveGitRef looks up ref as a branch, a tag, a full reference name and a commit hash, in this order.
+func resolveGitRef(repository *git.Repository, ref string) (core.Hash, error) {
+	if ref == "" {
+		ref = string(core.HEAD)
+	}
+
+	candidates := []core.ReferenceName{
+		core.ReferenceName("refs/heads/" + ref),
+		core.ReferenceName("refs/tags/" + ref),
+		core.ReferenceName(ref),
+	}
+	for _, name := range candidates {
+		reference, err := repository.Ref(name, true)
+		if err == nil {
+			return reference.Hash(), nil
+		}
+	}
+
+	if isCommitHash(ref) {
+		return core.NewHash(ref), nil
+	}
+
+	return core.ZeroHash, fmt.Errorf("no such branch, tag or commit")
+}
+
+var commitHashRegexp = regexp.MustCompile("^[0-9a-f]{40}$")
+
+func isCommitHash(ref string) bool {
+	return commitHashRegexp.MatchString(ref)
+}
+
+// peelToCommit follows annotated tags until a commit is found.
+func peelToCommit(repository *git.Repository, hash core.Hash) (*git.Commit, error) {
+	for i := 0; i < maxTagDepth; i++ {
+		object, err := repository.Object(core.AnyObject, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		switch object := object.(type) {
+		case *git.Commit:
+			return object, nil
+		case *git.Tag:
+			hash = object.Target
+		default:
+			return nil, fmt.Errorf("%s is not a commit", hash)
+		}
+	}
+
+	return nil, fmt.Errorf("too many nested tags at %s", hash)
+}
+
+func (store *gitStorage) readFile(name string, filename string) ([]byte, error) {
+	if !ValidFeatureName(name) {
+		return nil, &FeatureNotFoundError{Name: name}
+	}
+
+	path := name + "/" + filename
+	file, err := store.tree.File(path)
+	if err == git.ErrFileNotFound {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(content), nil
+}
+
+// Return a full feature data from the storage.
+// name:	exact name of the feature folder
+func (store *gitStorage) GetFeature(name string) (shared.Feature, error) {
+	meta, err := store.GetMeta(name)
+	if err != nil {
+		return shared.Feature{}, err
+	}
+
+	return readFeatureFolder(meta, func(filename string) ([]byte, error) {
+		return store.readFile(name, filename)
+	}, func() (map[string]shared.FeatureFile, error) {
+		return store.readAssets(name)
+	})
+}
+
+// readAssets reads all the regular files of a feature folder which are assets.
+func (store *gitStorage) readAssets(name string) (map[string]shared.FeatureFile, error) {
+	var dir *git.Tree
+	for _, entry := range store.tree.Entries {
+		if entry.Name == name && entry.Mode.IsDir() {
+			var err error
+			if dir, err = store.repository.Tree(entry.Hash); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if dir == nil {
+		return nil, &FeatureNotFoundError{Name: name}
+	}
+
+	var files map[string]shared.FeatureFile
+	err := dir.Files().ForEach(func(file *git.File) error {
+		if !file.Mode.IsRegular() || !isAssetPath(file.Name) {
+			return nil
+		}
+
+		content, err := file.Contents()
+		if err != nil {
+			return err
+		}
+		if files == nil {
+			files = map[string]shared.FeatureFile{}
+		}
+		files[file.Name] = shared.FeatureFile{Content: []byte(content), Executable: file.Mode&0111 != 0}
+		return nil
+	})
+	return files, err
+}
+
+// Use the given regex to return a list of FeatureMeta, sorted by feature name.
+// name		a regex used to filter out FeatureMeta
+func (store *gitStorage) SearchMeta(name *regexp.Regexp) ([]shared.FeatureMeta, error) {
+	result := []shared.FeatureMeta{}
+
+	for _, entry := range store.tree.Entries {
+		if !entry.Mode.IsDir() || !name.MatchString(entry.Name) {
+			continue
+		}
+
+		meta, err := store.GetMeta(entry.Name)
+		if IsFeatureNotFound(err) {
+			// not a feature folder
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		result = append(result, meta)
+	}
+
+	return result, nil
+}
+
+// Return a feature metadata from the storage. Unless declared in the meta file,
+// the time of the pinned commit is used as update time.
+// name:	exact name of the feature folder
+func (store *gitStorage) GetMeta(name string) (shared.FeatureMeta, error) {
+	content, err := store.readFile(name, MetaFilename)
+	if os.IsNotExist(err) {
+		return shared.FeatureMeta{}, &FeatureNotFoundError{Name: name}
+	}
+	if err != nil {
+		return shared.FeatureMeta{}, err
+	}
+
+	return parseFolderMeta(name, content, store.Commit.Committer.When)
+}
+
+// Resolve a list of features and their dependencies from the storage. Return non-nil err if at least one feature not found.
+// names:	an array of feature names
+func (store *gitStorage) Resolve(names ...string) ([]string, map[string]shared.Feature, error) {
+	return ResolveDependencies(store, names...)
+}
@@ -0,0 +1,125 @@
+package storageconnector
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{
+		"-c", "user.name=pazuzu", "-c", "user.email=pazuzu@example.org", "-c", "commit.gpgsign=false",
+	}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v failed: %s\n%s", args, err, out)
+	}
+	return string(out)
+}
+
+func setupGitRepository(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "pazuzu_git_storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runGit(t, dir, "init", "-q")
+	writeFeatureFolder(t, dir, "java", map[string]string{
+		MetaFilename:    "description: Java 7\n",
+		SnippetFilename: "RUN apt-get install -y openjdk-7-jdk",
+	})
+	writeFeatureFolder(t, dir, "lein", map[string]string{
+		MetaFilename:    "description: Leiningen\ndependencies: [java]\n",
+		SnippetFilename: "RUN curl -o /usr/bin/lein https://example.org/lein",
+		"bin/lein":      "#!/bin/sh",
+	})
+	if err := os.Chmod(filepath.Join(dir, "lein", "bin", "lein"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, "add", "-A")
+	runGit(t, dir, "commit", "-q", "-m", "initial catalogue")
+	runGit(t, dir, "tag", "-a", "v1", "-m", "first release")
+
+	writeFeatureFolder(t, dir, "java", map[string]string{
+		MetaFilename:    "description: Java 8\n",
+		SnippetFilename: "RUN apt-get install -y openjdk-8-jdk",
+	})
+	runGit(t, dir, "commit", "-q", "-a", "-m", "java 8")
+	// pack objects and refs like in a long-living repository
+	runGit(t, dir, "gc", "-q")
+
+	// uncommitted changes must never be visible
+	writeFeatureFolder(t, dir, "java", map[string]string{MetaFilename: "description: Java 9\n"})
+	writeFeatureFolder(t, dir, "python", map[string]string{
+		MetaFilename:    "description: Python\n",
+		SnippetFilename: "RUN apt-get install -y python",
+	})
+
+	return dir
+}
+
+func TestGitStorage(t *testing.T) {
+	dir := setupGitRepository(t)
+	defer os.RemoveAll(dir)
+
+	head := runGit(t, dir, "rev-parse", "HEAD")[:40]
+
+	refs := map[string]string{
+		"":     "Java 8",
+		"v1":   "Java 7",
+		head:   "Java 8",
+		"HEAD": "Java 8",
+	}
+	for ref, description := range refs {
+		store, err := NewGitStorage(dir, ref)
+		if err != nil {
+			t.Fatalf("should not fail for ref '%s': %s", ref, err)
+		}
+
+		meta, err := store.GetMeta("java")
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if meta.Description != description {
+			t.Errorf("Ref '%s' should read '%s' but read '%s'", ref, description, meta.Description)
+		}
+	}
+
+	store, err := NewGitStorage(filepath.Join(dir, ".git"), "v1")
+	if err != nil {
+		t.Fatalf("should open git directory: %s", err)
+	}
+
+	metas, err := store.SearchMeta(regexp.MustCompile(""))
+	if err != nil || len(metas) != 2 || metas[0].Name != "java" || metas[1].Name != "lein" {
+		t.Errorf("Wrong search result: %v, %v", metas, err)
+	}
+
+	if _, err := store.GetFeature("python"); err == nil {
+		t.Error("Uncommitted feature should not be found")
+	}
+
+	names, features, err := store.Resolve("lein")
+	if err != nil || !reflect.DeepEqual(names, []string{"java", "lein"}) {
+		t.Errorf("Wrong resolve result: %v, %v", names, err)
+	}
+	files := map[string]shared.FeatureFile{"bin/lein": {Content: []byte("#!/bin/sh"), Executable: true}}
+	if !reflect.DeepEqual(features["lein"].Files, files) || features["java"].Files != nil {
+		t.Errorf("Wrong asset files: %v, %v", features["lein"].Files, features["java"].Files)
+	}
+
+	if _, err := NewGitStorage(dir, "no-such-branch"); err == nil {
+		t.Error("Unknown ref should not be resolved")
+	}
+}
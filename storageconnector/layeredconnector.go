@@ -0,0 +1,109 @@
+package storageconnector
+
+import (
+	"regexp"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+// Layer is a named storage of the layered storage.
+type Layer struct {
+	Name    string
+	Storage StorageReader
+}
+
+// layeredStorage reads features from several storages in the given order. Lookups return the
+// feature of the first storage having it, so a feature shadows features with the same name
+// in all the following storages.
+type layeredStorage struct {
+	Layers []Layer
+}
+
+// NewLayeredStorage creates a storage reading from the given storages, the first one has
+// the highest priority.
+func NewLayeredStorage(layers ...Layer) *layeredStorage {
+	return &layeredStorage{Layers: layers}
+}
+
+// SearchMeta merges results of all the storages, shadowed features are left out.
+// FeatureMeta.Source is set to the name of the storage.
+func (store *layeredStorage) SearchMeta(name *regexp.Regexp) ([]shared.FeatureMeta, error) {
+	result := []shared.FeatureMeta{}
+	found := map[string]bool{}
+
+	for _, layer := range store.Layers {
+		metas, err := layer.Storage.SearchMeta(name)
+		if err != nil {
+			return []shared.FeatureMeta{}, err
+		}
+
+		for _, meta := range metas {
+			if found[meta.Name] {
+				continue
+			}
+			found[meta.Name] = true
+			meta.Source = layer.Name
+			result = append(result, meta)
+		}
+	}
+
+	return result, nil
+}
+
+// Return the metadata of the first storage having the feature. Only missing features, and
+// features not cached in offline mode, are looked up in the following storages. Any other
+// error is returned as is, as the feature might be shadowed by the storage failing.
+func (store *layeredStorage) GetMeta(name string) (shared.FeatureMeta, error) {
+	var missing error
+	for _, layer := range store.Layers {
+		meta, err := layer.Storage.GetMeta(name)
+		if isMissing(err) {
+			missing = firstMissing(missing, err)
+			continue
+		}
+		if err == nil {
+			meta.Source = layer.Name
+		}
+		return meta, err
+	}
+
+	return shared.FeatureMeta{}, firstMissing(missing, &FeatureNotFoundError{Name: name})
+}
+
+// Return the feature of the first storage having it, the same way as GetMeta.
+func (store *layeredStorage) GetFeature(name string) (shared.Feature, error) {
+	var missing error
+	for _, layer := range store.Layers {
+		feature, err := layer.Storage.GetFeature(name)
+		if isMissing(err) {
+			missing = firstMissing(missing, err)
+			continue
+		}
+		if err == nil {
+			feature.Meta.Source = layer.Name
+		}
+		return feature, err
+	}
+
+	return shared.Feature{}, firstMissing(missing, &FeatureNotFoundError{Name: name})
+}
+
+// isMissing returns true if err means the feature is to be looked up in the next storage.
+func isMissing(err error) bool {
+	return IsFeatureNotFound(err) || IsNotCached(err)
+}
+
+// firstMissing keeps the first NotCachedError, so a feature found nowhere is reported as
+// not cached rather than as not existing when a cache could not tell.
+func firstMissing(missing error, err error) error {
+	if IsNotCached(missing) {
+		return missing
+	}
+	return err
+}
+
+// Resolve features across all the storages, so a feature depends on the dependencies
+// visible first, wherever the feature itself comes from.
+func (store *layeredStorage) Resolve(names ...string) ([]string, map[string]shared.Feature, error) {
+	return ResolveDependencies(store, names...)
+}
@@ -0,0 +1,81 @@
+package storageconnector
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+func TestLayeredStorage(t *testing.T) {
+	team := &testSource{featureMap: featureMap{
+		"java": shared.NewFeature_str("java", "Patched Java", "team", nil, "RUN install patched java", ""),
+	}}
+	public := &testSource{featureMap: featureMap{
+		"java": shared.NewFeature_str("java", "Java", "pazuzu", nil, "RUN install java", ""),
+		"lein": shared.NewFeature_str("lein", "Leiningen", "pazuzu", []string{"java"}, "RUN install lein", ""),
+	}}
+	store := NewLayeredStorage(Layer{Name: "team", Storage: team}, Layer{Name: "public", Storage: public})
+
+	t.Run("First storage shadows the following ones", func(t *testing.T) {
+		names, features, err := store.Resolve("lein")
+		if err != nil || !reflect.DeepEqual(names, []string{"java", "lein"}) {
+			t.Fatalf("Wrong resolve result: %v, %v", names, err)
+		}
+		if features["java"].Snippet != "RUN install patched java" || features["java"].Meta.Source != "team" {
+			t.Errorf("Feature of the first storage should be used: %v", features["java"])
+		}
+		if features["lein"].Meta.Source != "public" {
+			t.Errorf("Feature should be read from the second storage: %v", features["lein"])
+		}
+	})
+
+	t.Run("SearchMeta merges results", func(t *testing.T) {
+		metas, err := store.SearchMeta(regexp.MustCompile(""))
+		if err != nil || len(metas) != 2 {
+			t.Fatalf("Wrong search result: %v, %v", metas, err)
+		}
+		if metas[0].Name != "java" || metas[0].Source != "team" || metas[1].Source != "public" {
+			t.Errorf("Wrong search result: %v", metas)
+		}
+	})
+
+	t.Run("Missing feature", func(t *testing.T) {
+		if _, err := store.GetFeature("python"); !IsFeatureNotFound(err) {
+			t.Errorf("Wrong error: %v", err)
+		}
+	})
+
+	t.Run("Offline cache missing the feature is skipped", func(t *testing.T) {
+		root, err := ioutil.TempDir("", "pazuzu_cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+		cache, err := NewCacheStorage(team, root, 0, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store := NewLayeredStorage(Layer{Name: "team", Storage: cache}, Layer{Name: "public", Storage: public})
+
+		feature, err := store.GetFeature("lein")
+		if err != nil || feature.Meta.Source != "public" {
+			t.Errorf("Feature should be read from the second storage: %v, %v", feature, err)
+		}
+		if _, err := store.GetMeta("python"); !IsNotCached(err) {
+			t.Errorf("Wrong error: %v", err)
+		}
+	})
+
+	t.Run("Failing storage is not skipped", func(t *testing.T) {
+		team.unavailable = true
+		defer func() { team.unavailable = false }()
+
+		if _, err := store.GetMeta("lein"); !IsStorageUnavailable(err) {
+			t.Errorf("Wrong error: %v", err)
+		}
+	})
+}
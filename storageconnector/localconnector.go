@@ -0,0 +1,287 @@
+package storageconnector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+type localStorage struct {
+	Root string // directory containing one folder per feature
+}
+
+func NewLocalStorage(root string) (*localStorage, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, &StorageUnavailableError{Storage: root, Err: err}
+	}
+	if !info.IsDir() {
+		return nil, &StorageUnavailableError{Storage: root, Err: fmt.Errorf("not a directory")}
+	}
+
+	return &localStorage{Root: root}, nil
+}
+
+func (store *localStorage) featurePath(name string, filename string) (string, error) {
+	if !ValidFeatureName(name) {
+		return "", &FeatureNotFoundError{Name: name}
+	}
+	return filepath.Join(store.Root, name, filename), nil
+}
+
+func (store *localStorage) readFile(name string, filename string) ([]byte, error) {
+	path, err := store.featurePath(name, filename)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// Return a full feature data from the storage.
+// name:	exact name of the feature folder
+func (store *localStorage) GetFeature(name string) (shared.Feature, error) {
+	meta, err := store.GetMeta(name)
+	if err != nil {
+		return shared.Feature{}, err
+	}
+
+	return readFeatureFolder(meta, func(filename string) ([]byte, error) {
+		return store.readFile(name, filename)
+	}, func() (map[string]shared.FeatureFile, error) {
+		return store.readAssets(name)
+	})
+}
+
+// readAssets reads all the regular files of a feature folder which are assets.
+func (store *localStorage) readAssets(name string) (map[string]shared.FeatureFile, error) {
+	dir, err := store.featurePath(name, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var files map[string]shared.FeatureFile
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !isAssetPath(rel) {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if files == nil {
+			files = map[string]shared.FeatureFile{}
+		}
+		files[rel] = shared.FeatureFile{Content: content, Executable: info.Mode()&0111 != 0}
+		return nil
+	})
+	return files, err
+}
+
+// Use the given regex to return a list of FeatureMeta, sorted by feature name.
+// name		a regex used to filter out FeatureMeta
+func (store *localStorage) SearchMeta(name *regexp.Regexp) ([]shared.FeatureMeta, error) {
+	result := []shared.FeatureMeta{}
+
+	entries, err := ioutil.ReadDir(store.Root)
+	if err != nil {
+		return result, &StorageUnavailableError{Storage: store.Root, Err: err}
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !name.MatchString(entry.Name()) {
+			continue
+		}
+
+		meta, err := store.GetMeta(entry.Name())
+		if IsFeatureNotFound(err) {
+			// not a feature folder
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		result = append(result, meta)
+	}
+
+	return result, nil
+}
+
+// Return a feature metadata from the storage.
+// name:	exact name of the feature folder
+func (store *localStorage) GetMeta(name string) (shared.FeatureMeta, error) {
+	path, err := store.featurePath(name, MetaFilename)
+	if err != nil {
+		return shared.FeatureMeta{}, err
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return shared.FeatureMeta{}, &FeatureNotFoundError{Name: name}
+	}
+	if err != nil {
+		return shared.FeatureMeta{}, err
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return shared.FeatureMeta{}, err
+	}
+
+	return parseFolderMeta(name, content, info.ModTime())
+}
+
+// Resolve a list of features and their dependencies from the storage. Return non-nil err if at least one feature not found.
+// names:	an array of feature names
+func (store *localStorage) Resolve(names ...string) ([]string, map[string]shared.Feature, error) {
+	return ResolveDependencies(store, names...)
+}
+
+// Create a new feature folder.
+func (store *localStorage) CreateFeature(feature shared.Feature) error {
+	dir, err := store.featurePath(feature.Meta.Name, "")
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(dir); err == nil {
+		return &FeatureExistsError{Name: feature.Meta.Name}
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+
+	// a half-written feature would make creating it again fail
+	if err := store.writeFeature(feature); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+// Replace meta data, snippets and asset files of a feature folder.
+func (store *localStorage) UpdateFeature(feature shared.Feature) error {
+	if _, err := store.GetMeta(feature.Meta.Name); err != nil {
+		return err
+	}
+
+	return store.writeFeature(feature)
+}
+
+// Delete a feature folder, unless other features depend on it.
+func (store *localStorage) DeleteFeature(name string) error {
+	dir, err := store.featurePath(name, "")
+	if err != nil {
+		return err
+	}
+	if _, err := store.GetMeta(name); err != nil {
+		return err
+	}
+
+	metas, err := store.SearchMeta(regexp.MustCompile(""))
+	if err != nil {
+		return err
+	}
+	for _, meta := range metas {
+		for _, dependency := range meta.Dependencies {
+			if dependency == name {
+				return fmt.Errorf("feature '%s' can not be deleted, '%s' depends on it", name, meta.Name)
+			}
+		}
+	}
+
+	return os.RemoveAll(dir)
+}
+
+func (store *localStorage) writeFeature(feature shared.Feature) error {
+	name := feature.Meta.Name
+
+	meta, err := marshalFolderMeta(feature.Meta)
+	if err != nil {
+		return err
+	}
+
+	files := map[string]string{
+		MetaFilename:        string(meta),
+		SnippetFilename:     feature.Snippet,
+		TestSnippetFilename: feature.TestSnippet,
+	}
+	for filename, content := range files {
+		path, err := store.featurePath(name, filename)
+		if err != nil {
+			return err
+		}
+
+		if filename == TestSnippetFilename && content == "" {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	return store.writeAssets(name, feature.Files)
+}
+
+// writeAssets replaces the asset files of a feature folder.
+func (store *localStorage) writeAssets(name string, files map[string]shared.FeatureFile) error {
+	for path := range files {
+		if !ValidAssetPath(path) {
+			return fmt.Errorf("invalid asset file '%s' of feature '%s'", path, name)
+		}
+	}
+
+	existing, err := store.readAssets(name)
+	if err != nil {
+		return err
+	}
+	for path := range existing {
+		if _, ok := files[path]; ok {
+			continue
+		}
+		if err := os.Remove(filepath.Join(store.Root, name, filepath.FromSlash(path))); err != nil {
+			return err
+		}
+	}
+
+	for path, file := range files {
+		target := filepath.Join(store.Root, name, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		mode := os.FileMode(0644)
+		if file.Executable {
+			mode = 0755
+		}
+		if err := ioutil.WriteFile(target, file.Content, mode); err != nil {
+			return err
+		}
+		// permissions of existing files are not changed by WriteFile
+		if err := os.Chmod(target, mode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package storageconnector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+func writeFeatureFolder(t *testing.T, root string, name string, files map[string]string) {
+	dir := filepath.Join(root, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for filename, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(filename))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func setupLocalStorage(t *testing.T) (*localStorage, string) {
+	root, err := ioutil.TempDir("", "pazuzu_local_storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFeatureFolder(t, root, "java", map[string]string{
+		MetaFilename:        "description: Java 8\nauthor: pazuzu\n",
+		SnippetFilename:     "RUN apt-get install -y openjdk-8-jdk",
+		TestSnippetFilename: "@test \"java\" {\n  java -version\n}",
+	})
+	writeFeatureFolder(t, root, "lein", map[string]string{
+		MetaFilename:    "description: Leiningen\ndependencies:\n  - java\nupdated_at: 2016-12-01T10:00:00Z\n",
+		SnippetFilename: "RUN curl -o /usr/bin/lein https://example.org/lein\nCOPY profiles.clj /root/.lein/",
+		"profiles.clj":  "{}",
+	})
+	writeFeatureFolder(t, root, "not-a-feature", map[string]string{"README": "nothing to see"})
+
+	store, err := NewLocalStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, root
+}
+
+func TestLocalStorage(t *testing.T) {
+	store, root := setupLocalStorage(t)
+	defer os.RemoveAll(root)
+
+	t.Run("GetFeature reads all files of a feature", func(t *testing.T) {
+		feature, err := store.GetFeature("java")
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if feature.Meta.Name != "java" || feature.Meta.Description != "Java 8" || feature.Meta.Author != "pazuzu" {
+			t.Errorf("Wrong meta: %v", feature.Meta)
+		}
+		if feature.Snippet != "RUN apt-get install -y openjdk-8-jdk" || feature.TestSnippet == "" {
+			t.Errorf("Wrong snippets: %v", feature)
+		}
+	})
+
+	t.Run("GetFeature reads asset files", func(t *testing.T) {
+		feature, err := store.GetFeature("lein")
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if !reflect.DeepEqual(feature.Files, map[string]shared.FeatureFile{"profiles.clj": {Content: []byte("{}")}}) {
+			t.Errorf("Wrong asset files: %v", feature.Files)
+		}
+	})
+
+	t.Run("GetMeta uses declared updated_at", func(t *testing.T) {
+		meta, err := store.GetMeta("lein")
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if meta.UpdatedAt.Year() != 2016 || !reflect.DeepEqual(meta.Dependencies, []string{"java"}) {
+			t.Errorf("Wrong meta: %v", meta)
+		}
+	})
+
+	t.Run("GetFeature fails for unknown and invalid names", func(t *testing.T) {
+		for _, name := range []string{"python", "not-a-feature", "..", "java/../lein"} {
+			if _, err := store.GetFeature(name); err == nil {
+				t.Errorf("Feature '%s' should not be found", name)
+			}
+		}
+	})
+
+	t.Run("SearchMeta skips folders without meta", func(t *testing.T) {
+		metas, err := store.SearchMeta(regexp.MustCompile(""))
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if len(metas) != 2 || metas[0].Name != "java" || metas[1].Name != "lein" {
+			t.Errorf("Wrong search result: %v", metas)
+		}
+
+		metas, err = store.SearchMeta(regexp.MustCompile("^le"))
+		if err != nil || len(metas) != 1 || metas[0].Name != "lein" {
+			t.Errorf("Wrong search result: %v, %v", metas, err)
+		}
+	})
+
+	t.Run("Resolve puts dependencies first", func(t *testing.T) {
+		names, features, err := store.Resolve("lein")
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+		if !reflect.DeepEqual(names, []string{"java", "lein"}) || len(features) != 2 {
+			t.Errorf("Wrong resolve result: %v", names)
+		}
+	})
+}
+
+func TestNewLocalStorageFailsOnMissingRoot(t *testing.T) {
+	if _, err := NewLocalStorage("/this/path/does/not/exist"); err == nil {
+		t.Error("Local storage should not be created")
+	}
+}
+
+func TestLocalStorageWriter(t *testing.T) {
+	store, root := setupLocalStorage(t)
+	defer os.RemoveAll(root)
+
+	clojure := shared.NewFeature_str("clojure", "Clojure", "pazuzu", []string{"lein"}, "RUN lein version", "")
+	clojure.Meta.Params = []shared.FeatureParam{{Name: "version", Default: "1.8", Description: "Clojure version"}}
+	clojure.Meta.Platforms = []string{"debian", "ubuntu:16.04"}
+	clojure.Files = map[string]shared.FeatureFile{
+		"bin/repl.sh": {Content: []byte("lein repl"), Executable: true},
+		"old.clj":     {Content: []byte("{}")},
+	}
+
+	if err := store.CreateFeature(clojure); err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+	if err := store.CreateFeature(clojure); !reflect.DeepEqual(err, &FeatureExistsError{Name: "clojure"}) {
+		t.Errorf("Existing feature should not be created again: %v", err)
+	}
+
+	scala := shared.NewFeature_str("scala", "Scala", "pazuzu", nil, "RUN install scala", "")
+	scala.Files = map[string]shared.FeatureFile{"../escape": {}}
+	if err := store.CreateFeature(scala); err == nil {
+		t.Error("Asset files outside of the feature folder should not be created")
+	}
+	if _, err := os.Stat(filepath.Join(root, "scala")); !os.IsNotExist(err) {
+		t.Errorf("Folder of the failed feature should be removed: %v", err)
+	}
+	scala.Files = nil
+	if err := store.CreateFeature(scala); err != nil {
+		t.Errorf("Failed feature should be created again: %s", err)
+	}
+
+	feature, err := store.GetFeature("clojure")
+	if err != nil || feature.Meta.Description != "Clojure" || feature.Snippet != "RUN lein version" {
+		t.Errorf("Created feature differs: %v, %v", feature, err)
+	}
+
+	if !reflect.DeepEqual(feature.Meta.Params, clojure.Meta.Params) {
+		t.Errorf("Created params differ: %v", feature.Meta.Params)
+	}
+	if !reflect.DeepEqual(feature.Meta.Platforms, clojure.Meta.Platforms) {
+		t.Errorf("Created platforms differ: %v", feature.Meta.Platforms)
+	}
+	if !reflect.DeepEqual(feature.Files, clojure.Files) {
+		t.Errorf("Created asset files differ: %v", feature.Files)
+	}
+
+	clojure.Meta.Description = "Clojure 1.8"
+	delete(clojure.Files, "old.clj")
+	if err := store.UpdateFeature(clojure); err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+	if feature, _ := store.GetFeature("clojure"); feature.Meta.Description != "Clojure 1.8" || !reflect.DeepEqual(feature.Files, clojure.Files) {
+		t.Errorf("Feature should be updated: %v", feature)
+	}
+
+	clojure.Files = map[string]shared.FeatureFile{"../escape": {}}
+	if err := store.UpdateFeature(clojure); err == nil {
+		t.Error("Asset files outside of the feature folder should not be written")
+	}
+
+	if err := store.UpdateFeature(shared.NewFeature_str("python", "", "", nil, "", "")); !IsFeatureNotFound(err) {
+		t.Errorf("Missing feature should not be updated: %v", err)
+	}
+
+	if err := store.DeleteFeature("lein"); err == nil {
+		t.Error("Feature with dependents should not be deleted")
+	}
+	if err := store.DeleteFeature("clojure"); err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+	if _, err := store.GetMeta("clojure"); !IsFeatureNotFound(err) {
+		t.Errorf("Feature should be deleted: %v", err)
+	}
+}
+
+func TestReadFeatureFolder(t *testing.T) {
+	_, root := setupLocalStorage(t)
+	defer os.RemoveAll(root)
+
+	feature, err := ReadFeatureFolder(filepath.Join(root, "lein") + "/")
+	if err != nil || feature.Meta.Name != "lein" {
+		t.Errorf("Feature should be read from its folder: %v, %v", feature, err)
+	}
+}
@@ -0,0 +1,44 @@
+package storageconnector
+
+import (
+	"regexp"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+// memoryStorage is a read-only storage of features which are not kept anywhere else,
+// such as custom snippets of a Pazuzufile.
+type memoryStorage struct {
+	Features featureMap
+}
+
+// NewMemoryStorage creates a storage of the given features.
+func NewMemoryStorage(features ...shared.Feature) *memoryStorage {
+	store := &memoryStorage{Features: featureMap{}}
+	for _, feature := range features {
+		store.Features[feature.Meta.Name] = feature
+	}
+	return store
+}
+
+func (store *memoryStorage) SearchMeta(name *regexp.Regexp) ([]shared.FeatureMeta, error) {
+	result := []shared.FeatureMeta{}
+	for featureName, feature := range store.Features {
+		if name.MatchString(featureName) {
+			result = append(result, feature.Meta)
+		}
+	}
+	return result, nil
+}
+
+func (store *memoryStorage) GetMeta(name string) (shared.FeatureMeta, error) {
+	return store.Features.GetMeta(name)
+}
+
+func (store *memoryStorage) GetFeature(name string) (shared.Feature, error) {
+	return store.Features.GetFeature(name)
+}
+
+func (store *memoryStorage) Resolve(names ...string) ([]string, map[string]shared.Feature, error) {
+	return ResolveDependencies(store.Features, names...)
+}
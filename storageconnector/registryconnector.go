@@ -1,6 +1,7 @@
 package storageconnector
 
 import (
+	"net/http"
 	"regexp"
 	"strconv"
 
@@ -13,10 +14,10 @@ import (
 )
 
 type registryStorage struct {
-	Hostname string // localhost
-	Port     int    // 8080
-	Scheme   string // http
-	Token    string // OAUTH2 Token
+	Hostname string      // localhost
+	Port     int         // 8080
+	Scheme   string      // http
+	Token    TokenSource // OAUTH2 bearer token, none if nil
 
 	Features  *features.Client
 	Transport runtime.ClientTransport
@@ -32,11 +33,17 @@ func (store *registryStorage) init(hostname string, port int, scheme string, for
 	schemes := []string{scheme}
 
 	transport := httptransport.New(host, path, schemes)
+	transport.Transport = &bearerTransport{base: transport.Transport, store: store}
 
 	store.Transport = transport
 	store.Features = features.New(transport, formats)
 }
 
+// location returns the base URL of the registry, for messages.
+func (store *registryStorage) location() string {
+	return store.Scheme + "://" + store.Hostname + ":" + strconv.Itoa(store.Port)
+}
+
 func NewRegistryStorage(hostname string, port int, scheme string, formats strfmt.Registry) (*registryStorage, error) {
 	if formats == nil {
 		formats = strfmt.Default
@@ -60,9 +67,9 @@ func (store *registryStorage) GetFeature(name string) (shared.Feature, error) {
 	params := features.NewGetFeaturesNameParams().WithName(name)
 	feature, err := store.Features.GetFeaturesName(params)
 	if err != nil {
-		return shared.Feature{}, err
+		return shared.Feature{}, registryError(store.location(), name, err)
 	}
-	return shared.NewFeature(feature.Payload), err
+	return shared.NewFeature(feature.Payload), nil
 }
 
 // Use the given regex to return a list of FeatureMeta.
@@ -75,13 +82,14 @@ func (store *registryStorage) SearchMeta(name *regexp.Regexp) ([]shared.FeatureM
 	params := features.NewGetFeaturesParams().WithQ(&nameStr)
 
 	features, err := store.Features.GetFeatures(params)
-	if err == nil {
-		for _, feature := range features.Payload.Features {
-			result = append(result, shared.NewMeta(feature.Meta))
-		}
+	if err != nil {
+		return result, registryError(store.location(), "", err)
 	}
 
-	return result, err
+	for _, feature := range features.Payload.Features {
+		result = append(result, shared.NewMeta(feature.Meta))
+	}
+	return result, nil
 }
 
 // Return a feature metadata from the storage.
@@ -97,6 +105,8 @@ func (store *registryStorage) GetMeta(name string) (shared.FeatureMeta, error) {
 }
 
 // Resolve a list of features and their dependencies from the storage. Return non-nil err if at least one feature not found.
+// The registry returns all the features at once, the order is computed client-side to be the same as for other storages.
+// If the registry can not resolve the features, they are resolved one by one to find out what is missing.
 // names:	an array of feature names
 func (store *registryStorage) Resolve(names ...string) ([]string, map[string]shared.Feature, error) {
 
@@ -104,18 +114,49 @@ func (store *registryStorage) Resolve(names ...string) ([]string, map[string]sha
 	params.Names = names
 
 	features, err := store.Features.GetDependencies(params)
+	if code, ok := registryStatusCode(err); ok && code == http.StatusNotFound {
+		return ResolveDependencies(store, names...)
+	}
 	if err != nil {
-		return []string{}, map[string]shared.Feature{}, err
+		return []string{}, map[string]shared.Feature{}, registryError(store.location(), "", err)
 	}
 
-	var slice []string
-	result := map[string]shared.Feature{}
-
+	fetched := featureMap{}
 	for _, feature := range features.Payload.Depedencies {
 		feature2 := shared.NewFeature(feature)
-		name := feature2.Meta.Name
-		result[name] = feature2
-		slice = append(slice, name)
+		fetched[feature2.Meta.Name] = feature2
+	}
+	return ResolveDependencies(fetched, names...)
+}
+
+// Create a new feature in the registry.
+func (store *registryStorage) CreateFeature(feature shared.Feature) error {
+	params := features.NewPostFeaturesParams().WithFeature(shared.NewModelFeature(feature))
+	_, err := store.Features.PostFeatures(params)
+	if err != nil {
+		return registryError(store.location(), feature.Meta.Name, err)
+	}
+	return nil
+}
+
+// Replace meta data and snippets of a feature in the registry.
+func (store *registryStorage) UpdateFeature(feature shared.Feature) error {
+	params := features.NewPutFeaturesNameParams().
+		WithName(feature.Meta.Name).
+		WithFeature(shared.NewModelFeature(feature))
+	_, err := store.Features.PutFeaturesName(params)
+	if err != nil {
+		return registryError(store.location(), feature.Meta.Name, err)
+	}
+	return nil
+}
+
+// Delete a feature from the registry. The registry refuses to delete features other features depend on.
+func (store *registryStorage) DeleteFeature(name string) error {
+	params := features.NewDeleteFeaturesNameParams().WithName(name)
+	_, err := store.Features.DeleteFeaturesName(params)
+	if err != nil {
+		return registryError(store.location(), name, err)
 	}
-	return slice, result, nil
+	return nil
 }
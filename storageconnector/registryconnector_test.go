@@ -0,0 +1,126 @@
+package storageconnector
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+// newTestRegistry starts a registry answering every request with the given status code.
+func newTestRegistry(t *testing.T, code int) (*registryStorage, *httptest.Server) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write([]byte(`{"code": "error", "message": "test"}`))
+	}))
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	store, err := NewRegistryStorage(u.Hostname(), port, u.Scheme, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, server
+}
+
+func TestRegistryStorageErrors(t *testing.T) {
+	java := shared.NewFeature_str("java", "Java", "pazuzu", nil, "RUN install java", "")
+
+	t.Run("Missing feature", func(t *testing.T) {
+		store, server := newTestRegistry(t, http.StatusNotFound)
+		defer server.Close()
+
+		if _, err := store.GetFeature("java"); !IsFeatureNotFound(err) {
+			t.Errorf("Wrong error: %v", err)
+		}
+		if err := store.DeleteFeature("java"); !IsFeatureNotFound(err) {
+			t.Errorf("Wrong error: %v", err)
+		}
+	})
+
+	t.Run("Existing feature", func(t *testing.T) {
+		store, server := newTestRegistry(t, http.StatusConflict)
+		defer server.Close()
+
+		if _, ok := store.CreateFeature(java).(*FeatureExistsError); !ok {
+			t.Error("Existing feature should be reported")
+		}
+	})
+
+	t.Run("Missing token", func(t *testing.T) {
+		store, server := newTestRegistry(t, http.StatusUnauthorized)
+		defer server.Close()
+
+		_, err := store.GetFeature("java")
+		if _, ok := err.(*UnauthorizedError); !ok || !IsStorageError(err) {
+			t.Errorf("Wrong error: %v", err)
+		}
+	})
+
+	t.Run("Registry failure", func(t *testing.T) {
+		store, server := newTestRegistry(t, http.StatusInternalServerError)
+		defer server.Close()
+
+		if err := store.UpdateFeature(java); !IsStorageUnavailable(err) {
+			t.Errorf("Wrong error: %v", err)
+		}
+	})
+
+	t.Run("Registry down", func(t *testing.T) {
+		store, server := newTestRegistry(t, http.StatusOK)
+		server.Close()
+
+		if _, _, err := store.Resolve("java"); !IsStorageUnavailable(err) {
+			t.Errorf("Wrong error: %v", err)
+		}
+	})
+}
+
+func TestRegistryStorageToken(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	store, err := NewRegistryStorage(u.Hostname(), port, u.Scheme, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("No token", func(t *testing.T) {
+		store.GetFeature("java")
+		if authorization != "" {
+			t.Errorf("No token should be sent: %s", authorization)
+		}
+	})
+
+	t.Run("Token is read from file on every request", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "pazuzu_token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		defer os.Remove(file.Name())
+
+		store.Token = FileToken(file.Name())
+		for _, token := range []string{"first", "second"} {
+			if err := ioutil.WriteFile(file.Name(), []byte(token+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			store.GetFeature("java")
+			if authorization != "Bearer "+token {
+				t.Errorf("Wrong authorization header: %s", authorization)
+			}
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package storageconnector
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+// FeatureGetter is the part of StorageReader needed to resolve dependencies.
+type FeatureGetter interface {
+	GetMeta(name string) (shared.FeatureMeta, error)
+	GetFeature(name string) (shared.Feature, error)
+}
+
+// MissingDependencyError is returned when a requested feature or one of its dependencies
+// can not be read from the storage, while the storage itself is available.
+type MissingDependencyError struct {
+	Chain []string // from the requested feature down to the missing one
+	Err   error    // reason given by the storage
+}
+
+func (e *MissingDependencyError) Error() string {
+	if len(e.Chain) == 1 {
+		return e.Err.Error()
+	}
+	name := e.Chain[len(e.Chain)-1]
+	return fmt.Sprintf("dependency '%s' not resolved (%s): %s", name, strings.Join(e.Chain, " -> "), e.Err)
+}
+
+// DependencyCycleError is returned when features depend on each other.
+type DependencyCycleError struct {
+	Chain []string // from the requested feature, the last name closes the cycle
+}
+
+func (e *DependencyCycleError) Error() string {
+	return fmt.Sprintf("dependency cycle detected: %s", strings.Join(e.Chain, " -> "))
+}
+
+const (
+	unvisited = iota
+	visiting
+	visited
+)
+
+type resolver struct {
+	storage FeatureGetter
+	state   map[string]int
+	chains  map[string][]string
+	order   []string
+}
+
+// ResolveDependencies builds the dependency graph of the given features from FeatureMeta.Dependencies
+// and returns its topological order together with all the features. The order is stable: features
+// are visited in the given order and dependencies in the declared order, every feature is placed
+// after all of its dependencies and as early as possible.
+//
+// MissingDependencyError or DependencyCycleError is returned if the graph can not be resolved,
+// errors about the storage itself (see IsStorageError) are passed through as is.
+func ResolveDependencies(storage FeatureGetter, names ...string) ([]string, map[string]shared.Feature, error) {
+	r := &resolver{
+		storage: storage,
+		state:   map[string]int{},
+		chains:  map[string][]string{},
+		order:   []string{},
+	}
+
+	for _, name := range names {
+		if err := r.visit(name, nil); err != nil {
+			return []string{}, map[string]shared.Feature{}, err
+		}
+	}
+
+	features := make(map[string]shared.Feature, len(r.order))
+	for _, name := range r.order {
+		feature, err := storage.GetFeature(name)
+		if err != nil {
+			return []string{}, map[string]shared.Feature{}, missingDependency(r.chains[name], err)
+		}
+		features[name] = feature
+	}
+
+	return r.order, features, nil
+}
+
+func (r *resolver) visit(name string, parents []string) error {
+	chain := append(parents[:len(parents):len(parents)], name)
+
+	switch r.state[name] {
+	case visited:
+		return nil
+	case visiting:
+		return &DependencyCycleError{Chain: chain}
+	}
+
+	r.state[name] = visiting
+	r.chains[name] = chain
+
+	meta, err := r.storage.GetMeta(name)
+	if err != nil {
+		return missingDependency(chain, err)
+	}
+
+	for _, dependency := range meta.Dependencies {
+		if err := r.visit(dependency, chain); err != nil {
+			return err
+		}
+	}
+
+	r.state[name] = visited
+	r.order = append(r.order, name)
+	return nil
+}
+
+func missingDependency(chain []string, err error) error {
+	if IsStorageError(err) {
+		return err
+	}
+	return &MissingDependencyError{Chain: chain, Err: err}
+}
+
+// featureMap is a FeatureGetter over features which are already fetched.
+type featureMap map[string]shared.Feature
+
+func (m featureMap) GetFeature(name string) (shared.Feature, error) {
+	feature, ok := m[name]
+	if !ok {
+		return shared.Feature{}, &FeatureNotFoundError{Name: name}
+	}
+	return feature, nil
+}
+
+func (m featureMap) GetMeta(name string) (shared.FeatureMeta, error) {
+	feature, err := m.GetFeature(name)
+	return feature.Meta, err
+}
@@ -0,0 +1,84 @@
+package storageconnector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zalando-incubator/pazuzu/shared"
+)
+
+func newFeatureMap(dependencies map[string][]string) featureMap {
+	m := featureMap{}
+	for name, deps := range dependencies {
+		m[name] = shared.NewFeature_str(name, "", "", deps, "RUN echo "+name, "")
+	}
+	return m
+}
+
+func TestResolveDependencies(t *testing.T) {
+	storage := newFeatureMap(map[string][]string{
+		"java":   {},
+		"maven":  {"java"},
+		"lein":   {"java", "curl"},
+		"curl":   {},
+		"node":   {"curl"},
+		"a":      {"b"},
+		"b":      {"c"},
+		"c":      {"a"},
+		"broken": {"java", "missing"},
+	})
+
+	t.Run("Orders dependencies first, in declared order", func(t *testing.T) {
+		names, features, err := ResolveDependencies(storage, "lein", "maven", "node")
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+
+		expected := []string{"java", "curl", "lein", "maven", "node"}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("Result differs from expected: %s", names)
+		}
+		if len(features) != len(expected) || features["curl"].Meta.Name != "curl" {
+			t.Errorf("Wrong features: %v", features)
+		}
+	})
+
+	t.Run("Order does not depend on the storage order", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			names, _, _ := ResolveDependencies(storage, "node", "lein", "node")
+			if !reflect.DeepEqual(names, []string{"curl", "node", "java", "lein"}) {
+				t.Fatalf("Result differs from expected: %s", names)
+			}
+		}
+	})
+
+	t.Run("Reports cycles", func(t *testing.T) {
+		_, _, err := ResolveDependencies(storage, "java", "a")
+		cycle, ok := err.(*DependencyCycleError)
+		if !ok {
+			t.Fatalf("Cycle should be detected: %v", err)
+		}
+		if !reflect.DeepEqual(cycle.Chain, []string{"a", "b", "c", "a"}) {
+			t.Errorf("Wrong cycle: %s", cycle)
+		}
+	})
+
+	t.Run("Reports missing dependencies", func(t *testing.T) {
+		_, _, err := ResolveDependencies(storage, "node", "broken")
+		missing, ok := err.(*MissingDependencyError)
+		if !ok {
+			t.Fatalf("Missing dependency should be detected: %v", err)
+		}
+		if !reflect.DeepEqual(missing.Chain, []string{"broken", "missing"}) {
+			t.Errorf("Wrong chain: %s", missing)
+		}
+	})
+
+	t.Run("Reports missing features", func(t *testing.T) {
+		_, _, err := ResolveDependencies(storage, "python")
+		missing, ok := err.(*MissingDependencyError)
+		if !ok || !reflect.DeepEqual(missing.Chain, []string{"python"}) {
+			t.Errorf("Missing feature should be detected: %v", err)
+		}
+	})
+}
@@ -21,10 +21,23 @@ type StorageReader interface {
 
 	// Resolve finds all dependencies for a given list of Feature names and returns them as a map of
 	// Features. The returned map will contain the Feature information for all listed names as well as
-	// the Feature information of all their direct or indirect dependencies.
+	// the Feature information of all their direct or indirect dependencies. The returned names are
+	// ordered as by ResolveDependencies.
 	//
 	// names:  The names of the features which dependencies should be resolved.
 	//
 	// If a feature can't be found or a dependency can't be resolved an error is returned.
 	Resolve(names ...string) ([]string, map[string]shared.Feature, error)
 }
+
+// StorageWriter defines an interface to publish Features to data sources
+type StorageWriter interface {
+	// CreateFeature adds a new Feature. FeatureExistsError is returned if the name is already taken.
+	CreateFeature(feature shared.Feature) error
+
+	// UpdateFeature replaces meta data and snippets of an existing Feature.
+	UpdateFeature(feature shared.Feature) error
+
+	// DeleteFeature removes a Feature. Features other features depend on can not be deleted.
+	DeleteFeature(name string) error
+}